@@ -14,6 +14,9 @@ import (
 	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
 )
 
+// version is set at build time: -ldflags "-X main.version=<version>"
+var version = "dev"
+
 func main() {
 	cfg, err := config.Load()
 	if err != nil {
@@ -37,7 +40,7 @@ func main() {
 	}))
 	slog.SetDefault(logger)
 
-	b, err := bridge.NewBridge(cfg)
+	b, err := bridge.NewBridge(cfg, bridge.Options{Version: version, LogLevel: programLevel})
 	if err != nil {
 		slog.Error("Failed to initialize bridge", "error", err)
 		os.Exit(1)
@@ -3,6 +3,7 @@ module github.com/chrisrickenbacher/lox-mqtt-bridge
 go 1.25.5
 
 require (
+	github.com/eclipse/paho.golang v0.23.0
 	github.com/eclipse/paho.mqtt.golang v1.5.1
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/websocket v1.5.3
@@ -0,0 +1,89 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+)
+
+// commandTarget describes the control a command is sent to, as seen by the policy
+type commandTarget struct {
+	Control  *loxone.Control
+	Room     string
+	Category string
+	Command  string // Loxone notation as built by BuildCommand
+}
+
+// authorize checks a command against read-only mode and the command policy
+func authorize(readOnly bool, policy *config.CommandPolicy, t commandTarget) error {
+	if readOnly {
+		return fmt.Errorf("bridge is read-only")
+	}
+	return checkPolicy(policy, t)
+}
+
+// checkPolicy checks a command against the command policy, nil allows everything
+func checkPolicy(policy *config.CommandPolicy, t commandTarget) error {
+	if policy == nil {
+		return nil
+	}
+	for i, rule := range policy.Rules {
+		if !ruleMatcher(rule).matches(t) {
+			continue
+		}
+		if rule.Effect == config.PolicyDeny {
+			return fmt.Errorf("denied by command policy rule %d", i+1)
+		}
+		return nil
+	}
+	if policy.Default == config.PolicyDeny {
+		return fmt.Errorf("denied by command policy default")
+	}
+	return nil
+}
+
+// ruleMatcher adds matching to config.PolicyRule without putting Loxone types into the config package
+type ruleMatcher config.PolicyRule
+
+func (r ruleMatcher) matches(t commandTarget) bool {
+	return matchAny(r.Rooms, func(s string) bool { return sanitize(s) == sanitize(t.Room) }) &&
+		matchAny(r.Categories, func(s string) bool { return sanitize(s) == sanitize(t.Category) }) &&
+		matchAny(r.Types, func(s string) bool { return strings.EqualFold(s, t.Control.Type) }) &&
+		matchAny(r.Controls, func(s string) bool { return matchesControl(s, t.Control) }) &&
+		matchAny(r.Commands, func(s string) bool { return matchesCommand(s, t.Command) })
+}
+
+// matchAny is true for an empty list (no restriction) or if any entry matches
+func matchAny(list []string, match func(s string) bool) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, s := range list {
+		if match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesControl(s string, ctrl *loxone.Control) bool {
+	if sanitize(s) == sanitize(ctrl.Name) {
+		return true
+	}
+	u, err := ParseUUID(s)
+	if err != nil {
+		return false
+	}
+	action, err := ParseUUID(ctrl.UUIDAction)
+	return err == nil && u == action
+}
+
+// matchesCommand matches the command name, ignoring arguments: "on" matches "on" and "on/1",
+// "hsv" matches "hsv(0,100,100)"
+func matchesCommand(name, cmd string) bool {
+	name = strings.ToLower(name)
+	cmd = strings.ToLower(cmd)
+	return cmd == name || strings.HasPrefix(cmd, name+"/") || strings.HasPrefix(cmd, name+"(")
+}
@@ -0,0 +1,65 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorize(t *testing.T) {
+	alarm := &loxone.Control{Name: "House Alarm", Type: "Alarm", UUIDAction: "20000000-0000-0000-0000000000000001"}
+	gate := &loxone.Control{Name: "Garage Gate", Type: "Gate", UUIDAction: "20000000-0000-0000-0000000000000002"}
+	light := &loxone.Control{Name: "Light", Type: "Switch", UUIDAction: "20000000-0000-0000-0000000000000003"}
+
+	policy := &config.CommandPolicy{
+		Rules: []config.PolicyRule{
+			// Alarm may be armed but not disarmed
+			{Effect: "allow", Types: []string{"Alarm"}, Commands: []string{"on", "delayedon"}},
+			{Effect: "deny", Types: []string{"alarm"}},
+			{Effect: "deny", Rooms: []string{"garage"}},
+			{Effect: "deny", Categories: []string{"Security"}},
+			{Effect: "deny", Controls: []string{"20000000-0000-0000-0000-000000000003"}, Commands: []string{"pulse"}},
+		},
+	}
+	require.NoError(t, policy.Validate())
+	cfg := &config.Config{Policy: policy}
+
+	tests := []struct {
+		name    string
+		target  commandTarget
+		allowed bool
+	}{
+		{"Alarm arm", commandTarget{Control: alarm, Command: "on"}, true},
+		{"Alarm arm with argument", commandTarget{Control: alarm, Command: "on/1"}, true},
+		{"Alarm disarm", commandTarget{Control: alarm, Command: "off"}, false},
+		{"Gate by room", commandTarget{Control: gate, Room: "Garage", Command: "open"}, false},
+		{"Gate in other room", commandTarget{Control: gate, Room: "Outside", Command: "open"}, true},
+		{"By category", commandTarget{Control: light, Category: "Security", Command: "On"}, false},
+		{"By control UUID and command", commandTarget{Control: light, Command: "Pulse"}, false},
+		{"Default allow", commandTarget{Control: light, Command: "On"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authorize(cfg.Bridge.ReadOnly, cfg.Policy, tt.target)
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	// Default deny turns the rules into an allowlist
+	cfg.Policy = &config.CommandPolicy{Default: "deny", Rules: []config.PolicyRule{{Effect: "allow", Controls: []string{"light"}}}}
+	require.NoError(t, cfg.Policy.Validate())
+	assert.NoError(t, authorize(cfg.Bridge.ReadOnly, cfg.Policy, commandTarget{Control: light, Command: "On"}))
+	assert.Error(t, authorize(cfg.Bridge.ReadOnly, cfg.Policy, commandTarget{Control: gate, Command: "open"}))
+
+	// Read-only wins over the policy
+	cfg.Bridge.ReadOnly = true
+	assert.Error(t, authorize(cfg.Bridge.ReadOnly, cfg.Policy, commandTarget{Control: light, Command: "On"}))
+}
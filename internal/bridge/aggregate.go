@@ -0,0 +1,114 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+)
+
+// aggregator coalesces state changes per control and triggers one flush per debounce window
+type aggregator struct {
+	mu      sync.Mutex
+	delay   time.Duration
+	flush   func(ctrl *loxone.Control)
+	pending map[*loxone.Control]*time.Timer
+	stopped bool
+}
+
+func newAggregator(delay time.Duration, flush func(ctrl *loxone.Control)) *aggregator {
+	return &aggregator{
+		delay:   delay,
+		flush:   flush,
+		pending: make(map[*loxone.Control]*time.Timer),
+	}
+}
+
+// Touch schedules a flush for the control unless one is already pending.
+// The window is not extended by further changes, so a streaming meter still publishes once per delay.
+func (a *aggregator) Touch(ctrl *loxone.Control) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stopped {
+		return
+	}
+	if _, ok := a.pending[ctrl]; ok {
+		return
+	}
+	a.pending[ctrl] = time.AfterFunc(a.delay, func() {
+		a.mu.Lock()
+		delete(a.pending, ctrl)
+		stopped := a.stopped
+		a.mu.Unlock()
+		if !stopped {
+			a.flush(ctrl)
+		}
+	})
+}
+
+// Stop cancels all pending flushes
+func (a *aggregator) Stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stopped = true
+	for ctrl, t := range a.pending {
+		t.Stop()
+		delete(a.pending, ctrl)
+	}
+}
+
+// ControlState is the aggregated state document of a control
+type ControlState struct {
+	States map[string]interface{} `json:"states"` // Key: Loxone state name, array states as list
+	Ts     string                 `json:"ts"`
+}
+
+// controlState builds the aggregated document from the cache, states without a value yet are omitted
+func (b *Bridge) controlState(ctrl *loxone.Control) ControlState {
+	doc := ControlState{
+		States: make(map[string]interface{}),
+		Ts:     time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, s := range b.reg().StatesOf(ctrl) {
+		p, ok := b.cache.Get(s.UUID)
+		if s.Index < 0 {
+			if ok {
+				doc.States[s.Name] = p.Value
+			}
+			continue
+		}
+
+		// Missing elements stay null to keep indexes aligned with the _N topics
+		list, _ := doc.States[s.Name].([]interface{})
+		for len(list) <= s.Index {
+			list = append(list, nil)
+		}
+		if ok {
+			list[s.Index] = p.Value
+		}
+		doc.States[s.Name] = list
+	}
+	return doc
+}
+
+// publishControlState publishes the aggregated document to <prefix>/<snr>/<room>/<control>/state
+func (b *Bridge) publishControlState(ctrl *loxone.Control) {
+	path, ok := b.reg().ControlPath(ctrl)
+	if !ok {
+		return
+	}
+
+	payload, err := json.Marshal(b.controlState(ctrl))
+	if err != nil {
+		slog.Error("Error marshaling control state", "control", ctrl.Name, "error", err)
+		return
+	}
+
+	topic := fmt.Sprintf("%s/%s/%s/state", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr, path)
+	if err := b.mqtt.Publish(topic, 0, true, payload); err != nil {
+		slog.Error("Failed to publish control state", "control", ctrl.Name, "error", err)
+	}
+}
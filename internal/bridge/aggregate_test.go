@@ -0,0 +1,73 @@
+package bridge
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestBridge_AggregatedControlState(t *testing.T) {
+	mockMQTT := new(MockMQTTProvider)
+	cfg := &config.Config{
+		Loxone: config.LoxoneConfig{Snr: "504F94A00000"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "loxone"},
+	}
+
+	uuidPos := "10000000-0000-0000-0000000000000001"
+	uuidUp := "10000000-0000-0000-0000000000000002"
+	uuidOut0 := "10000000-0000-0000-0000000000000003"
+	uuidOut1 := "10000000-0000-0000-0000000000000004"
+	structure := &loxone.LoxApp3{
+		Rooms: map[string]*loxone.Room{"r1": {Name: "Bedroom"}},
+		Controls: map[string]*loxone.Control{
+			"c1": {
+				Name: "Blinds",
+				Type: "Jalousie",
+				Room: "r1",
+				States: map[string]interface{}{
+					"position": uuidPos,
+					"up":       uuidUp,
+					"outputs":  []interface{}{uuidOut0, uuidOut1},
+				},
+			},
+		},
+	}
+
+	b := &Bridge{
+		cfg:      cfg,
+		mqtt:     mockMQTT,
+		registry: NewRegistry(structure, nil),
+		cache:    NewStateCache(),
+	}
+	b.aggregator = newAggregator(20*time.Millisecond, b.publishControlState)
+	defer b.aggregator.Stop()
+
+	mockMQTT.On("Publish", mock.MatchedBy(func(topic string) bool {
+		return topic != "loxone/504F94A00000/bedroom/blinds/state"
+	}), byte(0), true, mock.Anything).Return(nil)
+
+	var doc ControlState
+	mockMQTT.On("Publish", "loxone/504F94A00000/bedroom/blinds/state", byte(0), true, mock.Anything).
+		Run(func(args mock.Arguments) {
+			json.Unmarshal(args.Get(3).([]byte), &doc)
+		}).Return(nil).Once()
+
+	b.handleEvent(loxone.Event{UUID: uuidPos, Value: 0.25, Type: "Value"})
+	b.handleEvent(loxone.Event{UUID: uuidUp, Value: 1, Type: "Value"})
+	b.handleEvent(loxone.Event{UUID: uuidOut1, Value: 3, Type: "Value"})
+	b.handleEvent(loxone.Event{UUID: uuidPos, Value: 0.5, Type: "Value"})
+
+	time.Sleep(60 * time.Millisecond)
+
+	mockMQTT.AssertExpectations(t)
+	assert.Equal(t, map[string]interface{}{
+		"position": 0.5,
+		"up":       1.0,
+		"outputs":  []interface{}{nil, 3.0},
+	}, doc.States)
+}
@@ -0,0 +1,256 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+)
+
+// Audit sinks selectable via BRIDGE_AUDIT
+const (
+	AuditSinkMQTT   = "mqtt"
+	AuditSinkFile   = "file"
+	AuditSinkStdout = "stdout"
+)
+
+// Audit result codes
+const (
+	AuditOK             = "ok"
+	AuditQueued         = "queued"
+	AuditInvalid        = "invalid"
+	AuditDenied         = "denied"
+	AuditFailed         = "failed"
+	AuditUnknownControl = "unknown_control"
+)
+
+// AuditRecord describes one command request and its outcome
+type AuditRecord struct {
+	Ts         string            `json:"ts"`
+	Topic      string            `json:"topic"`
+	Payload    string            `json:"payload"`
+	Control    string            `json:"control,omitempty"`
+	Room       string            `json:"room,omitempty"`
+	Type       string            `json:"type,omitempty"`
+	UUID       string            `json:"uuid,omitempty"`    // uuidAction the command was sent to
+	Command    string            `json:"command,omitempty"` // Loxone command after translation and escaping
+	Result     string            `json:"result"`
+	Error      string            `json:"error,omitempty"`
+	DurationMs float64           `json:"durationMs"`
+	Client     map[string]string `json:"client,omitempty"` // MQTT v5 user properties of the request
+}
+
+// AuditSink stores audit records
+type AuditSink interface {
+	Write(rec AuditRecord) error
+	Close() error
+}
+
+// auditor fans out audit records to the configured sinks
+type auditor struct {
+	sinks []AuditSink
+}
+
+// newAuditor returns nil if no audit sink is configured
+func newAuditor(cfg *config.Config, publish func(topic string, payload []byte) error) (*auditor, error) {
+	if len(cfg.Bridge.Audit) == 0 {
+		return nil, nil
+	}
+
+	a := &auditor{}
+	for _, name := range cfg.Bridge.Audit {
+		switch name {
+		case AuditSinkMQTT:
+			topic := fmt.Sprintf("%s/%s/_audit", cfg.MQTT.TopicPrefix, cfg.Loxone.Snr)
+			a.sinks = append(a.sinks, &mqttAuditSink{topic: topic, publish: publish})
+		case AuditSinkFile:
+			if cfg.Bridge.AuditFile == "" {
+				a.Close()
+				return nil, fmt.Errorf("audit sink file requires BRIDGE_AUDIT_FILE")
+			}
+			f, err := newRotatingFile(cfg.Bridge.AuditFile, int64(cfg.Bridge.AuditFileMaxSizeMB)<<20, cfg.Bridge.AuditFileBackups)
+			if err != nil {
+				a.Close()
+				return nil, err
+			}
+			a.sinks = append(a.sinks, &jsonlAuditSink{w: f})
+		case AuditSinkStdout:
+			a.sinks = append(a.sinks, &jsonlAuditSink{w: os.Stdout})
+		default:
+			a.Close()
+			return nil, fmt.Errorf("invalid audit sink: %s (must be mqtt, file or stdout)", name)
+		}
+	}
+	return a, nil
+}
+
+// Record writes the record to all sinks, failures are logged
+func (a *auditor) Record(rec AuditRecord) {
+	if a == nil {
+		return
+	}
+	for _, s := range a.sinks {
+		if err := s.Write(rec); err != nil {
+			slog.Error("Failed to write audit record", "error", err)
+		}
+	}
+}
+
+func (a *auditor) Close() {
+	if a == nil {
+		return
+	}
+	for _, s := range a.sinks {
+		s.Close()
+	}
+}
+
+// audit records a command request. ctrl is nil if the control could not be resolved.
+func (b *Bridge) audit(req *mqtt.Message, ctrl *loxone.Control, started time.Time, result CommandResult) {
+	if b.auditor == nil {
+		return
+	}
+	rec := AuditRecord{
+		Ts:         started.UTC().Format(time.RFC3339Nano),
+		Topic:      req.Topic,
+		Payload:    string(req.Payload),
+		Command:    result.Command,
+		Result:     result.Code(),
+		Error:      result.Error,
+		DurationMs: float64(time.Since(started).Microseconds()) / 1000,
+	}
+	if ctrl != nil {
+		rec.Control = ctrl.Name
+		rec.Room = b.reg().RoomOf(ctrl)
+		rec.Type = ctrl.Type
+		rec.UUID = ctrl.UUIDAction
+	}
+	if req.Properties != nil {
+		rec.Client = req.Properties.User
+	}
+	b.auditor.Record(rec)
+}
+
+// mqttAuditSink publishes records to <prefix>/<snr>/_audit (not retained)
+type mqttAuditSink struct {
+	topic   string
+	publish func(topic string, payload []byte) error
+}
+
+func (s *mqttAuditSink) Write(rec AuditRecord) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.publish(s.topic, payload)
+}
+
+func (s *mqttAuditSink) Close() error { return nil }
+
+// jsonlAuditSink writes one JSON document per line
+type jsonlAuditSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *jsonlAuditSink) Write(rec AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+func (s *jsonlAuditSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok && s.w != os.Stdout {
+		return c.Close()
+	}
+	return nil
+}
+
+// rotatingFile is an append-only file that is rotated to <path>.1 .. <path>.<backups>
+// when it would exceed maxSize
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	f       *os.File
+	size    int64
+}
+
+func newRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	r.f = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			// The current file is still open, keep writing to it and retry with the next record
+			slog.Warn("Failed to rotate audit file", "path", r.path, "error", err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups by one and starts a new file, the oldest backup is removed.
+// The current file is closed last, so it stays usable if any step fails.
+func (r *rotatingFile) rotate() error {
+	if r.backups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", r.path, r.backups))
+		for i := r.backups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return err
+	}
+
+	old := r.f
+	if err := r.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
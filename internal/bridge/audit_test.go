@@ -0,0 +1,143 @@
+package bridge
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryAuditSink struct {
+	records []AuditRecord
+}
+
+func (s *memoryAuditSink) Write(rec AuditRecord) error {
+	s.records = append(s.records, rec)
+	return nil
+}
+
+func (s *memoryAuditSink) Close() error { return nil }
+
+func TestBridge_Audit(t *testing.T) {
+	mockLox := new(MockLoxoneProvider)
+	mockMQTT := new(MockMQTTProvider)
+	cfg := &config.Config{
+		Loxone: config.LoxoneConfig{Snr: "504F94A00000"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "loxone"},
+		Policy: &config.CommandPolicy{Default: config.PolicyAllow, Rules: []config.PolicyRule{
+			{Effect: config.PolicyDeny, Commands: []string{"pulse"}},
+		}},
+	}
+
+	uuidAction := "20000000-0000-0000-0000000000000007"
+	structure := &loxone.LoxApp3{
+		Rooms: map[string]*loxone.Room{"r1": {Name: "Living Room", UUID: "r1"}},
+		Controls: map[string]*loxone.Control{
+			"c1": {Name: "Light", Room: "r1", Type: "Switch", UUIDAction: uuidAction},
+		},
+	}
+	sink := &memoryAuditSink{}
+	b := &Bridge{cfg: cfg, lox: mockLox, mqtt: mockMQTT, registry: NewRegistry(structure, nil), policy: cfg.Policy,
+		auditor: &auditor{sinks: []AuditSink{sink}}}
+
+	topic := "loxone/504F94A00000/living-room/light/command"
+	mockLox.On("SendCommand", fmt.Sprintf("jdev/sps/io/%s/On", uuidAction)).Return(nil)
+	mockMQTT.On("Publish", topic+"/result", byte(0), false, mock.Anything).Return(nil)
+
+	b.handleMQTTMessage(&mqtt.Message{
+		Topic:      topic,
+		Payload:    []byte("On"),
+		Properties: &mqtt.Properties{User: map[string]string{"client_id": "dashboard"}},
+	})
+	b.handleMQTTMessage(&mqtt.Message{Topic: topic, Payload: []byte("Pulse")})
+	b.handleMQTTMessage(&mqtt.Message{Topic: topic, Payload: []byte("Open")})
+	b.handleMQTTMessage(&mqtt.Message{Topic: "loxone/504F94A00000/living-room/lamp/command", Payload: []byte("On")})
+
+	require.Len(t, sink.records, 4)
+	ok := sink.records[0]
+	assert.Equal(t, topic, ok.Topic)
+	assert.Equal(t, "On", ok.Payload)
+	assert.Equal(t, "Light", ok.Control)
+	assert.Equal(t, "Living Room", ok.Room)
+	assert.Equal(t, uuidAction, ok.UUID)
+	assert.Equal(t, "On", ok.Command)
+	assert.Equal(t, AuditOK, ok.Result)
+	assert.Equal(t, "dashboard", ok.Client["client_id"])
+
+	assert.Equal(t, AuditDenied, sink.records[1].Result)
+	assert.Equal(t, AuditInvalid, sink.records[2].Result)
+	assert.Equal(t, AuditUnknownControl, sink.records[3].Result)
+	assert.Empty(t, sink.records[3].Control)
+}
+
+func TestNewAuditor(t *testing.T) {
+	cfg := &config.Config{}
+	a, err := newAuditor(cfg, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+
+	cfg.Bridge.Audit = []string{AuditSinkFile}
+	_, err = newAuditor(cfg, nil)
+	assert.Error(t, err, "file sink without path")
+
+	cfg.Bridge.Audit = []string{"syslog"}
+	_, err = newAuditor(cfg, nil)
+	assert.Error(t, err)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	f, err := newRotatingFile(path, 100, 2)
+	require.NoError(t, err)
+	sink := &jsonlAuditSink{w: f}
+
+	// Each record is ~70 bytes, so every write rotates
+	for i := 0; i < 4; i++ {
+		require.NoError(t, sink.Write(AuditRecord{Topic: fmt.Sprintf("t%d", i), Result: AuditOK}))
+	}
+	require.NoError(t, sink.Close())
+
+	topicOf := func(p string) string {
+		file, err := os.Open(p)
+		require.NoError(t, err)
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		require.True(t, scanner.Scan())
+		var rec AuditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		return rec.Topic
+	}
+	assert.Equal(t, "t3", topicOf(path))
+	assert.Equal(t, "t2", topicOf(path+".1"))
+	assert.Equal(t, "t1", topicOf(path+".2"))
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err), "oldest backup is removed")
+}
+
+func TestRotatingFile_RenameFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	// A non-empty directory in place of the backup makes the rename fail
+	require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "blocked"), 0o750))
+	f, err := newRotatingFile(path, 100, 1)
+	require.NoError(t, err)
+	sink := &jsonlAuditSink{w: f}
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, sink.Write(AuditRecord{Topic: fmt.Sprintf("t%d", i), Result: AuditOK}))
+	}
+	require.NoError(t, sink.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(data), "\n"), "records are kept in the current file")
+}
@@ -3,59 +3,202 @@ package bridge
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
 	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
 	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+	"github.com/google/uuid"
 )
 
 // Bridge acts as the middleman between Loxone and MQTT
 type Bridge struct {
-	cfg      *config.Config
-	lox      LoxoneProvider
-	mqtt     MQTTProvider
-	registry *Registry
-	done     chan struct{}
+	cfg        *config.Config
+	lox        LoxoneProvider
+	mqtt       MQTTProvider
+	registry   *Registry             // Replaced on reload, read through reg()
+	policy     *config.CommandPolicy // Replaced on reload, read through commandPolicy()
+	formats    *payloadFormats
+	filter     *publishFilter
+	queue      *commandQueue      // nil if queueing is disabled
+	macros     *macroRunner       // nil if no macros are configured
+	optimistic *optimisticTracker // nil if optimistic publishing is disabled
+	auditor    *auditor           // nil if no audit sink is configured
+	publisher  *publisher         // nil publishes states synchronously
+	outbox     *outbox            // nil if states are not buffered while the broker is down
+	hass       *hassDiscovery     // nil if Home Assistant discovery is disabled
+	homie      *homieDevice       // nil if the Homie convention is disabled
+	zone       *time.Location     // Time zone of the Miniserver, nil uses the system zone
+	version    string
+	logLevel   *slog.LevelVar // nil if the level can't be changed at runtime
+	done       chan struct{}
+
+	regMu         sync.RWMutex // Guards registry and policy
+	sendMu        sync.Mutex   // Keeps commands in order while the queue is replayed
+	reconnecting  atomic.Bool
+	mqttConnected atomic.Bool // Set once Start connected, the offline status is published on Stop
+	managing      atomic.Bool // A management request publishing the whole tree is running
+
+	started         time.Time
+	loxoneConnected atomic.Bool
+
+	// Created in Start() together with the registry
+	cache      *StateCache
+	aggregator *aggregator
+}
+
+// reg returns the current registry
+func (b *Bridge) reg() *Registry {
+	b.regMu.RLock()
+	defer b.regMu.RUnlock()
+	return b.registry
+}
+
+// setRegistry replaces the registry, e.g. after the structure was reloaded
+func (b *Bridge) setRegistry(r *Registry) {
+	b.regMu.Lock()
+	defer b.regMu.Unlock()
+	b.registry = r
+}
+
+// commandPolicy returns the current command policy, nil allows everything
+func (b *Bridge) commandPolicy() *config.CommandPolicy {
+	b.regMu.RLock()
+	defer b.regMu.RUnlock()
+	return b.policy
+}
+
+// setPolicy replaces the command policy after the policy file was reloaded
+func (b *Bridge) setPolicy(p *config.CommandPolicy) {
+	b.regMu.Lock()
+	defer b.regMu.Unlock()
+	b.policy = p
+}
+
+// loxoneNow returns the current time in the Miniserver's zone
+func (b *Bridge) loxoneNow() time.Time {
+	if b.zone == nil {
+		return time.Now()
+	}
+	return time.Now().In(b.zone)
 }
 
 // New creates a new Bridge instance
-func NewBridge(cfg *config.Config) (*Bridge, error) {
-	lox := loxone.NewClient(cfg.Loxone)
-	mqttClient := mqtt.NewClient(cfg.MQTT)
+func NewBridge(cfg *config.Config, opts Options) (*Bridge, error) {
 	// Registry will be initialized in Start() after fetching structure
+	formats, err := newPayloadFormats(cfg.Bridge)
+	if err != nil {
+		return nil, err
+	}
+
+	zone, err := cfg.Loxone.Location()
+	if err != nil {
+		return nil, err
+	}
 
-	return &Bridge{
-		cfg:  cfg,
-		lox:  lox,
-		mqtt: mqttClient,
-		done: make(chan struct{}),
-	}, nil
+	b := &Bridge{
+		cfg:      cfg,
+		lox:      loxone.NewClient(cfg.Loxone),
+		formats:  formats,
+		policy:   cfg.Policy,
+		macros:   newMacroRunner(cfg.Macros),
+		version:  opts.Version,
+		logLevel: opts.LogLevel,
+		zone:     zone,
+		done:     make(chan struct{}),
+	}
+
+	// Before the MQTT client, the Homie device may replace the will
+	if cfg.Bridge.Homie {
+		b.homie = newHomieDevice(cfg.Bridge.HomiePrefix, cfg.Bridge.HomieVersion, cfg.Loxone.Snr)
+	}
+
+	mqttOpts := mqtt.Options{Will: b.will(), OnConnect: b.onMQTTConnect}
+	if cfg.MQTT.Version == 5 {
+		b.mqtt, err = mqtt.NewClientV5(cfg.MQTT, mqttOpts)
+	} else {
+		b.mqtt, err = mqtt.NewClient(cfg.MQTT, mqttOpts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b.filter, err = newPublishFilter(cfg.Bridge, b.republish)
+	if err != nil {
+		return nil, err
+	}
+	b.optimistic = newOptimisticTracker(cfg.Bridge, b.rollbackOptimistic)
+
+	if cfg.Bridge.HassDiscovery {
+		b.hass = newHassDiscovery(cfg.Bridge.HassDiscoveryPrefix)
+	}
+
+	if cfg.Bridge.BufferSize > 0 {
+		b.outbox, err = newOutbox(cfg.Bridge.BufferSize, cfg.Bridge.BufferDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Bridge.PublishWorkers > 0 {
+		b.publisher = newPublisher(cfg.Bridge.PublishWorkers, cfg.Bridge.PublishQueueSize, b.deliver,
+			func(msg *mqtt.Message, err error) {
+				slog.Warn("Failed to publish state", "topic", msg.Topic, "error", err)
+			})
+	}
+
+	b.auditor, err = newAuditor(cfg, func(topic string, payload []byte) error {
+		return b.mqtt.Publish(topic, 0, false, payload)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Bridge.CommandQueueSize > 0 {
+		b.queue = newCommandQueue(cfg.Bridge.CommandQueueSize, func(c *queuedCommand) {
+			slog.Warn("Queued command expired", "control", c.ctrl.Name, "command", c.cmd)
+			b.publishCommandResult(c.req, CommandResult{Command: c.cmd, Error: "expired while Loxone was disconnected"})
+		})
+	}
+
+	return b, nil
 }
 
 // Start begins the bridging process
 func (b *Bridge) Start(ctx context.Context) error {
 	defer b.Stop()
-	slog.Info("Starting Bridge...")
+	slog.Info("Starting Bridge...", "version", b.version)
+	b.started = time.Now()
 
 	if err := b.mqtt.Connect(); err != nil {
 		return fmt.Errorf("failed to connect to MQTT: %v", err)
 	}
+	b.mqttConnected.Store(true)
 
 	slog.Info("Connecting to Loxone...")
 	if err := b.lox.Connect(); err != nil {
 		return fmt.Errorf("failed to connect to Loxone: %v", err)
 	}
+	b.loxoneConnected.Store(true)
 
 	structure, err := b.lox.GetStructure()
 	if err != nil {
 		return fmt.Errorf("failed to get structure: %v", err)
 	}
 
-	b.registry = NewRegistry(structure)
+	// The cache and aggregator are set up first, a resync after an MQTT reconnect starts
+	// publishing once it sees the registry
+	b.cache = NewStateCache()
+	if b.cfg.Bridge.AggregateState {
+		b.aggregator = newAggregator(b.cfg.Bridge.AggregateDebounce, b.publishControlState)
+	}
+	b.setRegistry(NewRegistry(structure, b.cfg.Mapping))
 	slog.Info("Registry initialized", "controls", len(structure.Controls))
 
 	if err := b.lox.EnableStatusUpdates(); err != nil {
@@ -63,7 +206,68 @@ func (b *Bridge) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to enable status updates: %v", err)
 	}
 
-	// 1. Publish Miniserver Info
+	// 1. Publish Miniserver, Room and Control Infos
+	b.publishInfos(structure)
+
+	// 2. Publish Home Assistant discovery
+	if b.hass != nil {
+		if err := b.startDiscovery(); err != nil {
+			return fmt.Errorf("failed to subscribe to MQTT: %v", err)
+		}
+	}
+
+	// 3. Publish the Homie device
+	if b.homie != nil {
+		if err := b.startHomie(structure.MsInfo); err != nil {
+			return fmt.Errorf("failed to subscribe to MQTT: %v", err)
+		}
+	}
+
+	// Subscribe to Commands
+	// Format: loxone/<snr>/<room>/<control>/command
+	cmdTopic := fmt.Sprintf("%s/%s/+/+/command", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr)
+
+	if err := b.mqtt.Subscribe(cmdTopic, 1, b.handleMQTTMessage); err != nil {
+		return fmt.Errorf("failed to subscribe to MQTT: %v", err)
+	}
+
+	// Format: loxone/<snr>/<room>/<control>/<type>_<state>/set
+	setTopic := fmt.Sprintf("%s/%s/+/+/+/set", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr)
+
+	if err := b.mqtt.Subscribe(setTopic, 1, b.handleMQTTMessage); err != nil {
+		return fmt.Errorf("failed to subscribe to MQTT: %v", err)
+	}
+
+	// Format: loxone/<snr>/<room>/<control>/get
+	getTopic := fmt.Sprintf("%s/%s/+/+/get", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr)
+
+	if err := b.mqtt.Subscribe(getTopic, 1, b.handleMQTTMessage); err != nil {
+		return fmt.Errorf("failed to subscribe to MQTT: %v", err)
+	}
+
+	// Format: loxone/<snr>/_macro/<name>/run
+	if b.macros != nil {
+		macroTopic := fmt.Sprintf("%s/%s/_macro/+/run", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr)
+		if err := b.mqtt.Subscribe(macroTopic, 1, b.handleMacroMessage); err != nil {
+			return fmt.Errorf("failed to subscribe to MQTT: %v", err)
+		}
+	}
+
+	// Format: loxone/_bridge/request/<action>
+	if b.cfg.Bridge.Management {
+		if err := b.mqtt.Subscribe(b.managementTopic("request", "+"), 1, b.handleManagementMessage); err != nil {
+			return fmt.Errorf("failed to subscribe to MQTT: %v", err)
+		}
+	}
+
+	return b.runEventLoop(ctx)
+}
+
+// publishInfos publishes the retained _info documents of the Miniserver, its rooms and controls
+func (b *Bridge) publishInfos(structure *loxone.LoxApp3) {
+	registry := b.reg()
+
+	// Miniserver Info
 	// Topic: <prefix>/<snr>/_info
 	infoTopic := fmt.Sprintf("%s/%s/_info", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr)
 	infoPayload, _ := json.Marshal(structure.MsInfo)
@@ -71,7 +275,7 @@ func (b *Bridge) Start(ctx context.Context) error {
 		slog.Error("Failed to publish MS info", "error", err)
 	}
 
-	// 2. Publish Room Infos
+	// Room Infos
 	// Topic: <prefix>/<snr>/<room>/_info
 	for _, room := range structure.Rooms {
 		roomTopic := fmt.Sprintf("%s/%s/%s/_info", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr, sanitize(room.Name))
@@ -79,101 +283,219 @@ func (b *Bridge) Start(ctx context.Context) error {
 		b.mqtt.Publish(roomTopic, 1, true, roomPayload)
 	}
 
-	// 3. Publish Control Infos
+	// Control Infos
 	// Topic: <prefix>/<snr>/<room>/<control>/_info
 	// Iterate through registry's controlLookup to get all controls that are mapped
-	for _, ctrl := range b.registry.controlLookup {
-		// Lookup room name again to be safe/consistent
-		roomName := "unknown"
-		if r, ok := structure.Rooms[ctrl.Room]; ok {
-			roomName = r.Name
-		}
-
-		ctrlTopic := fmt.Sprintf("%s/%s/%s/%s/_info",
-			b.cfg.MQTT.TopicPrefix,
-			b.cfg.Loxone.Snr,
-			sanitize(roomName),
-			sanitize(ctrl.Name))
-
-		ctrlPayload, _ := json.Marshal(ctrl)
+	for path, ctrl := range registry.controlLookup {
+		ctrlTopic := fmt.Sprintf("%s/%s/%s/_info", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr, path)
+
+		ctrlPayload, _ := json.Marshal(controlInfo{
+			Control:     ctrl,
+			StateTopics: registry.StateTopics(ctrl),
+			Commands:    CommandUsages(ctrl.Type),
+			SetTopics:   registry.SetTopics(ctrl),
+		})
 		b.mqtt.Publish(ctrlTopic, 1, true, ctrlPayload)
 	}
+}
 
-	// Subscribe to Commands
-	// Format: loxone/<snr>/<room>/<control>/command
-	cmdTopic := fmt.Sprintf("%s/%s/+/+/command", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr)
-
-	if err := b.mqtt.Subscribe(cmdTopic, 1, b.handleMQTTMessage); err != nil {
-		return fmt.Errorf("failed to subscribe to MQTT: %v", err)
-	}
-
-	return b.runEventLoop(ctx)
+// controlInfo is the control _info document, extended with the topic segment of each state,
+// the commands accepted by its type and its writable states
+type controlInfo struct {
+	*loxone.Control
+	StateTopics map[string]interface{} `json:"stateTopics"`
+	Commands    []string               `json:"commands,omitempty"`  // Accepted commands, see BuildCommand
+	SetTopics   []string               `json:"setTopics,omitempty"` // State segments accepting .../<segment>/set
 }
 
 type Payload struct {
-	Value interface{} `json:"value"`
-	Ts    string      `json:"ts"`
+	Value   interface{} `json:"value"`
+	Typed   interface{} `json:"typed,omitempty"`   // Decoded value, see DecodeValue
+	Rounded *float64    `json:"rounded,omitempty"` // Value rounded to the precision of the display format
+	Unit    string      `json:"unit,omitempty"`
+	Text    string      `json:"text,omitempty"` // Display text as shown in the Loxone app
+	Ts      string      `json:"ts"`
+
+	// Optimistic marks a value expected from a sent command, not yet confirmed by Loxone
+	Optimistic bool `json:"optimistic,omitempty"`
+}
+
+// newPayload builds the payload of a numeric state value, decoded in the Miniserver's zone and formatted
+func newPayload(state *State, v float64, zone *time.Location) Payload {
+	payload := Payload{
+		Value: v,
+		Typed: DecodeValue(state.Control.Type, state.Name, v, zone),
+		Ts:    time.Now().UTC().Format(time.RFC3339),
+	}
+	if state.Format != nil {
+		rounded := state.Format.Round(v)
+		payload.Rounded = &rounded
+		payload.Unit = state.Format.Unit
+		payload.Text = state.Format.Text(v)
+	}
+	return payload
 }
 
 func (b *Bridge) runEventLoop(ctx context.Context) error {
 	slog.Info("Starting Event Loop...")
+
+	var metrics <-chan time.Time
+	if b.publisher != nil && b.cfg.Bridge.MetricsInterval > 0 {
+		ticker := time.NewTicker(b.cfg.Bridge.MetricsInterval)
+		defer ticker.Stop()
+		metrics = ticker.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
 		case <-b.done:
 			return nil
+		case <-metrics:
+			b.publishMetrics()
 		case event := <-b.lox.GetEvents():
-			u, err := ParseUUID(event.UUID)
-			if err != nil {
-				slog.Error("Invalid UUID in event", "uuid", event.UUID, "error", err)
-				continue
+			b.handleEvent(event)
+		case connected := <-b.lox.ConnectionState():
+			b.loxoneConnected.Store(connected)
+			b.publishLoxoneStatus(connected)
+			if connected {
+				slog.Info("Loxone connected")
+				b.publishHomieState(HomieReady)
+				go b.replayQueue()
+			} else {
+				b.publishHomieState(HomieLost)
+				go b.reconnectLoxone(ctx)
 			}
+		}
+	}
+}
 
-			state, found := b.registry.LookupState(u)
-			if !found {
-				continue
-			}
+func (b *Bridge) handleEvent(event loxone.Event) {
+	u, err := ParseUUID(event.UUID)
+	if err != nil {
+		slog.Error("Invalid UUID in event", "uuid", event.UUID, "error", err)
+		return
+	}
 
-			// Topic: <prefix>/<snr>/<room>/<control>/<type>_<state>
-			// Example: loxone/504.../living-room/light-switch/switch_active
-			topic := fmt.Sprintf("%s/%s/%s/%s/%s_%s",
-				b.cfg.MQTT.TopicPrefix,
-				b.cfg.Loxone.Snr,
-				sanitize(state.RoomName),
-				sanitize(state.Control.Name),
-				sanitize(state.Control.Type),
-				sanitize(state.Name),
-			)
-
-			// Construct Payload
-			payload := Payload{
-				Value: event.Value,
-				Ts:    time.Now().UTC().Format(time.RFC3339),
-			}
+	state, found := b.reg().LookupState(u)
+	if !found {
+		return
+	}
 
-			// Handle Text events specifically if needed,
-			// event.Value is float64, event.Text is string.
-			if event.Type == "Text" {
-				payload.Value = event.Text
-			}
+	// Construct Payload
+	payload := newPayload(state, event.Value, b.zone)
 
-			jsonPayload, err := json.Marshal(payload)
-			if err != nil {
-				slog.Error("Error marshaling payload", "error", err)
-				continue
-			}
+	// Handle Text events specifically if needed,
+	// event.Value is float64, event.Text is string.
+	if event.Type == "Text" {
+		payload = Payload{
+			Value: DecodeText(state.Control.Type, state.Name, event.Text),
+			Ts:    payload.Ts,
+		}
+	}
+
+	if b.cache != nil {
+		b.cache.Set(u, payload)
+	}
+
+	// The confirmation of an optimistic value always replaces it, even if unchanged
+	if b.optimistic.Confirm(u) {
+		if b.filter != nil {
+			b.filter.Record(u, payload.Value, time.Now())
+		}
+	} else if b.filter != nil && !b.filter.Allow(state, payload.Value, time.Now()) {
+		return
+	}
 
-			err = b.mqtt.Publish(topic, 0, true, jsonPayload)
-			if err != nil {
+	b.publishState(state, payload)
+}
+
+// publishState encodes and publishes a state payload to <prefix>/<snr>/<room>/<control>/<type>_<state>,
+// mirrored to <prefix>/<snr>/uuid/<stateUUID> if UUID topics are enabled
+func (b *Bridge) publishState(state *State, payload Payload) {
+	// Example: loxone/504.../living-room/light-switch/switch_active
+	topic := fmt.Sprintf("%s/%s/%s", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr, state.Path())
+
+	encoded, err := b.formats.For(state).Encode(newMessage(state, payload, topic))
+	if err != nil {
+		slog.Error("Error encoding payload", "topic", topic, "error", err)
+		return
+	}
+
+	msgs := []*mqtt.Message{b.stateMessage(state, topic, encoded)}
+	if b.cfg.Bridge.UUIDTopics {
+		uuidTopic := fmt.Sprintf("%s/%s/uuid/%s", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr, LoxoneUUID(state.UUID))
+		msgs = append(msgs, b.stateMessage(state, uuidTopic, encoded))
+	}
+	msgs = append(msgs, b.homieMessages(state, payload)...)
+
+	// The pipeline reports failures through its error callback
+	if b.publisher != nil {
+		b.publisher.Submit(state.UUID, msgs, nil)
+	} else {
+		for _, msg := range msgs {
+			if err := b.deliver(msg); err != nil {
 				slog.Error("Failed to publish MQTT message", "error", err)
 			}
 		}
 	}
+
+	if b.aggregator != nil {
+		b.aggregator.Touch(state.Control)
+	}
+}
+
+// stateMessage builds a retained state message. With MQTT v5 it carries the state's
+// identity as user properties and the configured message expiry.
+func (b *Bridge) stateMessage(state *State, topic string, payload []byte) *mqtt.Message {
+	msg := &mqtt.Message{Topic: topic, Payload: payload, Retained: true}
+	if b.cfg.MQTT.Version == 5 {
+		msg.Properties = &mqtt.Properties{
+			MessageExpiry: b.cfg.MQTT.MessageExpiry,
+			User: map[string]string{
+				"uuid":    LoxoneUUID(state.UUID),
+				"control": state.Control.Name,
+				"type":    state.Control.Type,
+				"room":    state.RoomName,
+				"state":   state.Name,
+			},
+		}
+	}
+	return msg
+}
+
+// sendMessage publishes a message, through PublishMessage only if it has v5 properties
+func (b *Bridge) sendMessage(msg *mqtt.Message) error {
+	if msg.Properties == nil {
+		return b.mqtt.Publish(msg.Topic, msg.QoS, msg.Retained, msg.Payload)
+	}
+	return b.mqtt.PublishMessage(msg)
 }
 
-func (b *Bridge) handleMQTTMessage(topic string, payload []byte) {
+// republish publishes the cached value of a state, used by the publish filter's
+// trailing-edge flushes and heartbeats
+func (b *Bridge) republish(u uuid.UUID) {
+	state, found := b.reg().LookupState(u)
+	if !found {
+		return
+	}
+	payload, ok := b.cache.Get(u)
+	if !ok {
+		return
+	}
+	if b.filter != nil {
+		b.filter.Record(u, payload.Value, time.Now())
+	}
+	b.publishState(state, payload)
+}
+
+func (b *Bridge) handleMQTTMessage(msg *mqtt.Message) {
 	// Expected: <prefix>/<snr>/<room>/<control>/command
+	//       or: <prefix>/<snr>/uuid/<uuidAction>/command
+	//       or: <prefix>/<snr>/<room>/<control>/<type>_<state>/set
+	//       or: <prefix>/<snr>/<room>/<control>/get
+	topic := msg.Topic
 
 	// Construct the root path: prefix/snr
 	root := fmt.Sprintf("%s/%s", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr)
@@ -188,39 +510,289 @@ func (b *Bridge) handleMQTTMessage(topic string, payload []byte) {
 	suffix = strings.TrimPrefix(suffix, "/")
 
 	parts := strings.Split(suffix, "/")
-	// Expected: room, control, command (3 parts)
-	if len(parts) != 3 {
-		slog.Warn("Ignoring malformed topic suffix", "suffix", suffix)
+	switch {
+	case len(parts) == 3 && (parts[2] == "command" || parts[2] == "get"):
+	case len(parts) == 4 && parts[3] == "set":
+		b.handleSet(msg, parts[0], parts[1], parts[2])
 		return
-	}
-
-	if parts[2] != "command" {
+	case len(parts) == 3 || len(parts) == 4:
+		return
+	default:
+		slog.Warn("Ignoring malformed topic suffix", "suffix", suffix)
 		return
 	}
 
 	room := parts[0]
 	control := parts[1]
 
-	// Look up Control
-	ctrl, found := b.registry.LookupControlByPath(room, control)
+	// Look up Control, <prefix>/<snr>/uuid/<uuidAction>/command addresses it by UUID
+	var ctrl *loxone.Control
+	var found bool
+	if room == "uuid" && b.cfg.Bridge.UUIDTopics {
+		ctrl, found = b.reg().LookupControlByAction(control)
+	} else {
+		ctrl, found = b.reg().LookupControlByPath(room, control)
+	}
 	if !found {
 		slog.Warn("Command received for unknown control", "room", room, "control", control)
+		b.audit(msg, nil, time.Now(), CommandResult{Error: "unknown control", code: AuditUnknownControl})
 		return
 	}
 
+	if parts[2] == "get" {
+		b.handleGet(msg, ctrl)
+		return
+	}
+	b.sendCommand(msg, ctrl, msg.Payload)
+}
+
+// handleGet responds with the current state document of a control
+func (b *Bridge) handleGet(req *mqtt.Message, ctrl *loxone.Control) {
+	payload, err := json.Marshal(b.controlState(ctrl))
+	if err != nil {
+		slog.Error("Error marshaling control state", "control", ctrl.Name, "error", err)
+		return
+	}
+	b.respond(req, payload)
+}
+
+// handleSet translates a value written to a state's set topic into the control's command
+func (b *Bridge) handleSet(req *mqtt.Message, room, control, segment string) {
+	state, found := b.reg().LookupStateBySegment(room, control, segment)
+	if !found {
+		slog.Warn("Set received for unknown state", "room", room, "control", control, "state", segment)
+		b.audit(req, nil, time.Now(), CommandResult{Error: "unknown state", code: AuditUnknownControl})
+		return
+	}
+
+	setter, ok := SetterOf(state)
+	if !ok {
+		result := CommandResult{Error: fmt.Sprintf("state %s of %s is read only", state.Name, state.Control.Type), code: AuditInvalid}
+		b.audit(req, state.Control, time.Now(), result)
+		b.publishCommandResult(req, result)
+		return
+	}
+
+	var cmd string
+	value, err := parseSetValue(req.Payload)
+	if err == nil {
+		cmd, err = setter(setRequest{Value: value, Now: b.loxoneNow(), TempOverride: b.cfg.Bridge.TempOverride})
+	}
+	if err != nil {
+		slog.Warn("Rejected invalid set value", "control", state.Control.Name, "state", state.Name, "error", err)
+		result := CommandResult{Error: err.Error(), code: AuditInvalid}
+		b.audit(req, state.Control, time.Now(), result)
+		b.publishCommandResult(req, result)
+		return
+	}
+
+	b.sendCommand(req, state.Control, []byte(cmd))
+}
+
+// sendCommand validates a command payload and sends it to the control's UUIDAction,
+// reporting the outcome to the requester, see publishCommandResult
+func (b *Bridge) sendCommand(req *mqtt.Message, ctrl *loxone.Control, payload []byte) {
+	started := time.Now()
+	result := b.execCommand(req, ctrl, payload)
+	b.audit(req, ctrl, started, result)
+	b.publishCommandResult(req, result)
+}
+
+// execCommand validates, authorizes and sends (or queues) a command. Results of commands
+// completed later, e.g. after a replay, are published for the request req.
+func (b *Bridge) execCommand(req *mqtt.Message, ctrl *loxone.Control, payload []byte) CommandResult {
 	// Important: We must send the command to the Control's UUIDAction
 	targetUUID := ctrl.UUIDAction
 	if targetUUID == "" {
 		slog.Warn("Control has no UUIDAction", "control", ctrl.Name)
-		return
+		return CommandResult{Error: "control has no UUIDAction"}
 	}
 
-	val := string(payload)
-	slog.Info("Sending command to Loxone", "control", ctrl.Name, "uuid", targetUUID, "type", ctrl.Type, "value", val)
+	cmd, err := BuildCommand(ctrl.Type, payload)
+	if err != nil {
+		slog.Warn("Rejected invalid command", "control", ctrl.Name, "type", ctrl.Type, "payload", string(payload), "error", err)
+		return CommandResult{Error: err.Error(), code: AuditInvalid}
+	}
 
-	cmd := fmt.Sprintf("jdev/sps/io/%s/%s", targetUUID, val)
-	if err := b.lox.SendCommand(cmd); err != nil {
+	target := commandTarget{
+		Control:  ctrl,
+		Room:     b.reg().RoomOf(ctrl),
+		Category: b.reg().CategoryOf(ctrl),
+		Command:  cmd,
+	}
+	if err := authorize(b.cfg.Bridge.ReadOnly, b.commandPolicy(), target); err != nil {
+		slog.Warn("Command denied", "audit", true, "topic", req.Topic, "control", ctrl.Name, "uuid", targetUUID,
+			"type", ctrl.Type, "room", target.Room, "category", target.Category, "command", cmd, "reason", err)
+		return CommandResult{Command: cmd, Error: err.Error(), code: AuditDenied}
+	}
+
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+
+	// Commands queued earlier go first
+	if b.queue != nil && b.queue.Len() > 0 {
+		return b.enqueue(req, ctrl, cmd, payload)
+	}
+
+	slog.Info("Sending command to Loxone", "control", ctrl.Name, "uuid", targetUUID, "type", ctrl.Type, "value", cmd)
+
+	err = b.lox.SendCommand(fmt.Sprintf("jdev/sps/io/%s/%s", targetUUID, cmd))
+	if errors.Is(err, loxone.ErrNotConnected) && b.queue != nil {
+		return b.enqueue(req, ctrl, cmd, payload)
+	}
+	if err != nil {
 		slog.Error("Failed to send command to Loxone", "error", err)
+		return CommandResult{Command: cmd, Error: err.Error()}
+	}
+	b.publishOptimistic(ctrl, cmd)
+	return CommandResult{OK: true, Command: cmd}
+}
+
+// enqueue holds a command until Loxone is connected again, superseding older commands of the control
+func (b *Bridge) enqueue(req *mqtt.Message, ctrl *loxone.Control, cmd string, payload []byte) CommandResult {
+	ttl, err := commandTTL(payload)
+	if err != nil {
+		return CommandResult{Command: cmd, Error: err.Error()}
+	}
+	if ttl == 0 {
+		ttl = b.cfg.Bridge.CommandTTL
+	}
+
+	superseded, err := b.queue.Push(&queuedCommand{req: req, ctrl: ctrl, cmd: cmd, deadline: time.Now().Add(ttl)})
+	for _, old := range superseded {
+		b.publishCommandResult(old.req, CommandResult{Command: old.cmd, Error: "superseded by a newer command"})
+	}
+	if err != nil {
+		slog.Warn("Failed to queue command", "control", ctrl.Name, "command", cmd, "error", err)
+		return CommandResult{Command: cmd, Error: err.Error()}
+	}
+
+	slog.Info("Loxone not connected, command queued", "control", ctrl.Name, "command", cmd, "ttl", ttl)
+	return CommandResult{OK: true, Queued: true, Command: cmd}
+}
+
+// replayQueue sends the queued commands in order after a reconnect
+func (b *Bridge) replayQueue() {
+	if b.queue == nil {
+		return
+	}
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+
+	for {
+		c, ok := b.queue.Pop()
+		if !ok {
+			return
+		}
+		err := b.lox.SendCommand(fmt.Sprintf("jdev/sps/io/%s/%s", c.ctrl.UUIDAction, c.cmd))
+		if errors.Is(err, loxone.ErrNotConnected) {
+			b.queue.PushFront(c)
+			return
+		}
+		if err != nil {
+			slog.Error("Failed to send queued command to Loxone", "error", err)
+			b.publishCommandResult(c.req, CommandResult{Command: c.cmd, Error: err.Error()})
+			continue
+		}
+		slog.Info("Sent queued command to Loxone", "control", c.ctrl.Name, "command", c.cmd)
+		b.publishCommandResult(c.req, CommandResult{OK: true, Command: c.cmd})
+	}
+}
+
+// loxoneReconnectDelay is the first backoff delay of reconnectLoxone, doubled up to a minute
+var loxoneReconnectDelay = time.Second
+
+// reconnectLoxone re-establishes the Loxone connection with exponential backoff
+func (b *Bridge) reconnectLoxone(ctx context.Context) {
+	if !b.reconnecting.CompareAndSwap(false, true) {
+		return
+	}
+	defer b.reconnecting.Store(false)
+
+	delay := loxoneReconnectDelay
+	for {
+		slog.Info("Reconnecting to Loxone", "delay", delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.done:
+			return
+		case <-time.After(delay):
+		}
+
+		err := b.lox.Connect()
+		if err == nil {
+			if err = b.lox.EnableStatusUpdates(); err != nil {
+				// Close the new connection, the next Connect would leave its read loop running
+				b.lox.Disconnect()
+			}
+		}
+		if err == nil {
+			return
+		}
+		slog.Error("Failed to reconnect to Loxone", "error", err)
+		if delay *= 2; delay > time.Minute {
+			delay = time.Minute
+		}
+	}
+}
+
+// CommandResult reports the outcome of a command, see publishCommandResult
+type CommandResult struct {
+	OK      bool   `json:"ok"`
+	Queued  bool   `json:"queued,omitempty"`  // Held until Loxone is connected, the final result follows
+	Command string `json:"command,omitempty"` // Loxone command as sent, after validation and escaping
+	Error   string `json:"error,omitempty"`
+	Ts      string `json:"ts"`
+
+	code string // Audit result code if not derived from OK and Queued, see Code
+}
+
+// Code returns the audit result code
+func (r CommandResult) Code() string {
+	switch {
+	case r.code != "":
+		return r.code
+	case r.Queued:
+		return AuditQueued
+	case r.OK:
+		return AuditOK
+	}
+	return AuditFailed
+}
+
+// publishCommandResult reports the result of a command request, see respond
+func (b *Bridge) publishCommandResult(req *mqtt.Message, result CommandResult) {
+	result.Ts = time.Now().UTC().Format(time.RFC3339)
+	payload, _ := json.Marshal(result)
+	b.respond(req, payload)
+}
+
+// respond publishes the response to a request on <request topic>/result, see respondOn
+func (b *Bridge) respond(req *mqtt.Message, payload []byte) {
+	b.respondOn(req, req.Topic+"/result", payload)
+}
+
+// respondOn publishes the response to a request. MQTT v5 requests with a response topic get it
+// there together with their correlation data, all others on topic.
+func (b *Bridge) respondOn(req *mqtt.Message, topic string, payload []byte) {
+	if responseTopic := req.ResponseTopic(); responseTopic != "" {
+		err := b.mqtt.PublishMessage(&mqtt.Message{
+			Topic:   responseTopic,
+			Payload: payload,
+			Properties: &mqtt.Properties{
+				CorrelationData: req.Properties.CorrelationData,
+				ContentType:     "application/json",
+			},
+		})
+		if err != nil {
+			slog.Error("Failed to publish response", "topic", responseTopic, "error", err)
+		}
+		return
+	}
+
+	if err := b.mqtt.Publish(topic, 0, false, payload); err != nil {
+		slog.Error("Failed to publish response", "topic", topic, "error", err)
 	}
 }
 
@@ -230,6 +802,24 @@ func (b *Bridge) Stop() {
 	default:
 		close(b.done)
 	}
+	if b.aggregator != nil {
+		b.aggregator.Stop()
+	}
+	if b.filter != nil {
+		b.filter.Stop()
+	}
+	if b.queue != nil {
+		b.queue.Stop()
+	}
+	if b.optimistic != nil {
+		b.optimistic.Stop()
+	}
+	b.auditor.Close()
+	b.publisher.Stop(publishDrainTimeout)
 	b.lox.Close()
+	if b.mqttConnected.CompareAndSwap(true, false) {
+		b.publishHomieState(HomieDisconnected)
+		b.publishOffline()
+	}
 	b.mqtt.Close()
-}
\ No newline at end of file
+}
@@ -3,6 +3,7 @@ package bridge
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -10,7 +11,10 @@ import (
 
 	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
 	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBridge_Start_And_Events(t *testing.T) {
@@ -60,10 +64,15 @@ func TestBridge_Start_And_Events(t *testing.T) {
 
 	// 4. Subscribe
 	mockMQTT.On("Subscribe", "loxone/504F94A00000/+/+/command", mock.Anything, mock.Anything).Return(nil)
+	mockMQTT.On("Subscribe", "loxone/504F94A00000/+/+/+/set", mock.Anything, mock.Anything).Return(nil)
+	mockMQTT.On("Subscribe", "loxone/504F94A00000/+/+/get", mock.Anything, mock.Anything).Return(nil)
 
 	// 5. Event Loop Setup
 	events := make(chan loxone.Event, 1)
 	mockLox.On("GetEvents").Return((<-chan loxone.Event)(events))
+	connState := make(chan bool, 1)
+	connState <- true
+	mockLox.On("ConnectionState").Return((<-chan bool)(connState))
 
 	// 6. Event Processing Expectation
 	// When we send the event, we expect a SPECIFIC publish
@@ -75,7 +84,13 @@ func TestBridge_Start_And_Events(t *testing.T) {
 		return p["value"] == 1.0
 	})).Return(nil)
 
-	// 7. Cleanup
+	// 7. Availability: Miniserver connection, offline on shutdown
+	mockMQTT.On("Publish", "loxone/504F94A00000/_status/loxone", byte(1), false, StatusOnline).Return(nil).Once()
+	mockMQTT.On("Publish", "loxone/504F94A00000/_status/loxone", byte(1), false, StatusOffline).Return(nil).Once()
+	mockMQTT.On("Publish", "loxone/504F94A00000/_status", byte(1), true, StatusOffline).Return(nil).Once()
+	mockMQTT.On("Publish", "loxone/_bridge/status", byte(1), false, StatusOffline).Return(nil).Once()
+
+	// 8. Cleanup
 	mockLox.On("Close").Return()
 	mockMQTT.On("Close").Return()
 
@@ -144,7 +159,7 @@ func TestBridge_CommandHandling(t *testing.T) {
 		cfg:      cfg,
 		lox:      mockLox,
 		mqtt:     mockMQTT,
-		registry: NewRegistry(structure), // Manually inject registry
+		registry: NewRegistry(structure, nil), // Manually inject registry
 	}
 
 	// Expectation: SendCommand
@@ -152,13 +167,262 @@ func TestBridge_CommandHandling(t *testing.T) {
 	// It sends "On" as string.
 	cmdStr := fmt.Sprintf("jdev/sps/io/%s/On", uuidAction)
 	mockLox.On("SendCommand", cmdStr).Return(nil)
+	mockMQTT.On("Publish", "loxone/504F94A00000/living-room/light/command/result", byte(0), false, mock.MatchedBy(func(p interface{}) bool {
+		return strings.Contains(string(p.([]byte)), `"ok":true,"command":"On"`)
+	})).Return(nil)
 
 	// Trigger Command
 	// Topic: loxone/504F94A00000/living-room/light/command
 	topic := "loxone/504F94A00000/living-room/light/command"
 	payload := []byte("On")
 
-	b.handleMQTTMessage(topic, payload)
+	b.handleMQTTMessage(&mqtt.Message{Topic: topic, Payload: payload})
+
+	mockLox.AssertExpectations(t)
+	mockMQTT.AssertExpectations(t)
+}
+
+func TestBridge_CommandHandling_JSONAndRejected(t *testing.T) {
+	mockLox := new(MockLoxoneProvider)
+	mockMQTT := new(MockMQTTProvider)
+	cfg := &config.Config{
+		Loxone: config.LoxoneConfig{Snr: "504F94A00000"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "loxone"},
+	}
+
+	uuidAction := "20000000-0000-0000-0000-000000000002"
+	structure := &loxone.LoxApp3{
+		Rooms: map[string]*loxone.Room{"r1": {Name: "Living Room"}},
+		Controls: map[string]*loxone.Control{
+			"c1": {Name: "Blind", Room: "r1", Type: "Jalousie", UUIDAction: uuidAction},
+		},
+	}
+	b := &Bridge{cfg: cfg, lox: mockLox, mqtt: mockMQTT, registry: NewRegistry(structure, nil)}
+
+	topic := "loxone/504F94A00000/living-room/blind/command"
+	resultTopic := topic + "/result"
+
+	mockLox.On("SendCommand", fmt.Sprintf("jdev/sps/io/%s/manualPosition/50", uuidAction)).Return(nil)
+	mockMQTT.On("Publish", resultTopic, byte(0), false, mock.MatchedBy(func(p interface{}) bool {
+		return strings.Contains(string(p.([]byte)), `"ok":true`)
+	})).Return(nil).Once()
+	mockMQTT.On("Publish", resultTopic, byte(0), false, mock.MatchedBy(func(p interface{}) bool {
+		return strings.Contains(string(p.([]byte)), `"ok":false`)
+	})).Return(nil).Once()
+
+	b.handleMQTTMessage(&mqtt.Message{Topic: topic, Payload: []byte(`{"cmd":"manualPosition","args":[50]}`)})
+	// Not a number: rejected without reaching Loxone
+	b.handleMQTTMessage(&mqtt.Message{Topic: topic, Payload: []byte("manualPosition/half")})
+
+	mockLox.AssertExpectations(t)
+	mockMQTT.AssertExpectations(t)
+}
+
+func TestBridge_SetTopics(t *testing.T) {
+	mockLox := new(MockLoxoneProvider)
+	mockMQTT := new(MockMQTTProvider)
+	cfg := &config.Config{
+		Loxone: config.LoxoneConfig{Snr: "504F94A00000"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "loxone"},
+	}
+
+	uuidAction := "20000000-0000-0000-0000-000000000003"
+	structure := &loxone.LoxApp3{
+		Rooms: map[string]*loxone.Room{"r1": {Name: "Living Room", UUID: "r1"}},
+		Controls: map[string]*loxone.Control{
+			"c1": {
+				Name: "Blind", Room: "r1", Type: "Jalousie", UUIDAction: uuidAction,
+				States: map[string]interface{}{
+					"position": "30000000-0000-0000-0000-000000000001",
+					"up":       "30000000-0000-0000-0000-000000000002",
+				},
+			},
+		},
+	}
+	b := &Bridge{cfg: cfg, lox: mockLox, mqtt: mockMQTT, registry: NewRegistry(structure, nil)}
+
+	setTopic := "loxone/504F94A00000/living-room/blind/jalousie_position/set"
+	mockLox.On("SendCommand", fmt.Sprintf("jdev/sps/io/%s/manualPosition/50", uuidAction)).Return(nil).Twice()
+	mockMQTT.On("Publish", setTopic+"/result", byte(0), false, mock.MatchedBy(func(p interface{}) bool {
+		return strings.Contains(string(p.([]byte)), `"ok":true`)
+	})).Return(nil).Twice()
+
+	// Same scale as the state topic (0..1), raw and as JSON envelope
+	b.handleMQTTMessage(&mqtt.Message{Topic: setTopic, Payload: []byte("0.5")})
+	b.handleMQTTMessage(&mqtt.Message{Topic: setTopic, Payload: []byte(`{"value":0.5}`)})
+
+	// Read-only state
+	readOnly := "loxone/504F94A00000/living-room/blind/jalousie_up/set"
+	mockMQTT.On("Publish", readOnly+"/result", byte(0), false, mock.MatchedBy(func(p interface{}) bool {
+		return strings.Contains(string(p.([]byte)), "read only")
+	})).Return(nil).Once()
+	b.handleMQTTMessage(&mqtt.Message{Topic: readOnly, Payload: []byte("1")})
+
+	mockLox.AssertExpectations(t)
+	mockMQTT.AssertExpectations(t)
+
+	assert.Equal(t, []string{"jalousie_position"}, b.registry.SetTopics(structure.Controls["c1"]))
+}
+
+func TestBridge_UUIDTopics(t *testing.T) {
+	mockLox := new(MockLoxoneProvider)
+	mockMQTT := new(MockMQTTProvider)
+	cfg := &config.Config{
+		Loxone: config.LoxoneConfig{Snr: "504F94A00000"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "loxone"},
+		Bridge: config.BridgeConfig{UUIDTopics: true},
+	}
+
+	uuidAction := "20000000-0000-0000-0000000000000004" // 8-4-4-16 as in the structure file
+	uuidActive := "30000000-0000-0000-0000000000000004"
+	structure := &loxone.LoxApp3{
+		Rooms: map[string]*loxone.Room{"r1": {Name: "Living Room", UUID: "r1"}},
+		Controls: map[string]*loxone.Control{
+			"c1": {
+				Name: "Light", Room: "r1", Type: "Switch", UUIDAction: uuidAction,
+				States: map[string]interface{}{"active": uuidActive},
+			},
+		},
+	}
+	b := &Bridge{cfg: cfg, lox: mockLox, mqtt: mockMQTT, registry: NewRegistry(structure, nil)}
+
+	// Command by UUIDAction, in either notation
+	topic := "loxone/504F94A00000/uuid/20000000-0000-0000-0000-000000000004/command"
+	mockLox.On("SendCommand", fmt.Sprintf("jdev/sps/io/%s/Off", uuidAction)).Return(nil)
+	mockMQTT.On("Publish", topic+"/result", byte(0), false, mock.Anything).Return(nil)
+	b.handleMQTTMessage(&mqtt.Message{Topic: topic, Payload: []byte("Off")})
+
+	// State mirrored to the UUID namespace
+	mockMQTT.On("Publish", "loxone/504F94A00000/living-room/light/switch_active", byte(0), true, mock.Anything).Return(nil)
+	mockMQTT.On("Publish", "loxone/504F94A00000/uuid/"+uuidActive, byte(0), true, mock.Anything).Return(nil)
+	b.handleEvent(loxone.Event{UUID: uuidActive, Value: 1, Type: "Value"})
+
+	mockLox.AssertExpectations(t)
+	mockMQTT.AssertExpectations(t)
+}
+
+func TestBridge_MQTTv5(t *testing.T) {
+	mockLox := new(MockLoxoneProvider)
+	mockMQTT := new(MockMQTTProvider)
+	cfg := &config.Config{
+		Loxone: config.LoxoneConfig{Snr: "504F94A00000"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "loxone", Version: 5, MessageExpiry: time.Hour},
+	}
+
+	uuidAction := "20000000-0000-0000-0000000000000005"
+	uuidActive := "30000000-0000-0000-0000000000000005"
+	structure := &loxone.LoxApp3{
+		Rooms: map[string]*loxone.Room{"r1": {Name: "Living Room", UUID: "r1"}},
+		Controls: map[string]*loxone.Control{
+			"c1": {
+				Name: "Light", Room: "r1", Type: "Switch", UUIDAction: uuidAction,
+				States: map[string]interface{}{"active": uuidActive},
+			},
+		},
+	}
+	b := &Bridge{cfg: cfg, lox: mockLox, mqtt: mockMQTT, registry: NewRegistry(structure, nil), cache: NewStateCache()}
+
+	// Command result goes to the response topic with the correlation data
+	mockLox.On("SendCommand", fmt.Sprintf("jdev/sps/io/%s/On", uuidAction)).Return(nil)
+	mockMQTT.On("PublishMessage", mock.MatchedBy(func(m *mqtt.Message) bool {
+		return m.Topic == "app/responses" && string(m.Properties.CorrelationData) == "req-1" &&
+			strings.Contains(string(m.Payload), `"ok":true`)
+	})).Return(nil).Once()
+	b.handleMQTTMessage(&mqtt.Message{
+		Topic:      "loxone/504F94A00000/living-room/light/command",
+		Payload:    []byte("On"),
+		Properties: &mqtt.Properties{ResponseTopic: "app/responses", CorrelationData: []byte("req-1")},
+	})
+
+	// State messages carry user properties and the message expiry
+	mockMQTT.On("PublishMessage", mock.MatchedBy(func(m *mqtt.Message) bool {
+		return m.Topic == "loxone/504F94A00000/living-room/light/switch_active" && m.Retained &&
+			m.Properties.MessageExpiry == time.Hour && m.Properties.User["uuid"] == uuidActive &&
+			m.Properties.User["type"] == "Switch"
+	})).Return(nil).Once()
+	b.handleEvent(loxone.Event{UUID: uuidActive, Value: 1, Type: "Value"})
+
+	// get without a response topic answers on <topic>/result
+	getTopic := "loxone/504F94A00000/living-room/light/get"
+	mockMQTT.On("Publish", getTopic+"/result", byte(0), false, mock.MatchedBy(func(p interface{}) bool {
+		return strings.Contains(string(p.([]byte)), `"states":{"active":1}`)
+	})).Return(nil).Once()
+	b.handleMQTTMessage(&mqtt.Message{Topic: getTopic})
+
+	mockLox.AssertExpectations(t)
+	mockMQTT.AssertExpectations(t)
+}
+
+func TestBridge_CommandHandling_ReadOnly(t *testing.T) {
+	mockLox := new(MockLoxoneProvider)
+	mockMQTT := new(MockMQTTProvider)
+	cfg := &config.Config{
+		Loxone: config.LoxoneConfig{Snr: "504F94A00000"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "loxone"},
+		Bridge: config.BridgeConfig{ReadOnly: true},
+	}
+
+	structure := &loxone.LoxApp3{
+		Rooms: map[string]*loxone.Room{"r1": {Name: "Garage", UUID: "r1"}},
+		Controls: map[string]*loxone.Control{
+			"c1": {Name: "Gate", Room: "r1", Type: "Gate", UUIDAction: "20000000-0000-0000-0000-000000000005"},
+		},
+	}
+	b := &Bridge{cfg: cfg, lox: mockLox, mqtt: mockMQTT, registry: NewRegistry(structure, nil)}
+
+	// No SendCommand expectation: the command must not reach Loxone
+	topic := "loxone/504F94A00000/garage/gate/command"
+	mockMQTT.On("Publish", topic+"/result", byte(0), false, mock.MatchedBy(func(p interface{}) bool {
+		return strings.Contains(string(p.([]byte)), "read-only")
+	})).Return(nil)
+	b.handleMQTTMessage(&mqtt.Message{Topic: topic, Payload: []byte("open")})
+
+	mockLox.AssertExpectations(t)
+	mockMQTT.AssertExpectations(t)
+}
+
+func TestBridge_CommandQueue(t *testing.T) {
+	mockLox := new(MockLoxoneProvider)
+	mockMQTT := new(MockMQTTProvider)
+	cfg := &config.Config{
+		Loxone: config.LoxoneConfig{Snr: "504F94A00000"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "loxone"},
+		Bridge: config.BridgeConfig{CommandQueueSize: 10, CommandTTL: time.Minute},
+	}
+
+	uuidAction := "20000000-0000-0000-0000-000000000006"
+	structure := &loxone.LoxApp3{
+		Rooms: map[string]*loxone.Room{"r1": {Name: "Living Room", UUID: "r1"}},
+		Controls: map[string]*loxone.Control{
+			"c1": {Name: "Blind", Room: "r1", Type: "Jalousie", UUIDAction: uuidAction},
+		},
+	}
+	b := &Bridge{cfg: cfg, lox: mockLox, mqtt: mockMQTT, registry: NewRegistry(structure, nil)}
+	b.queue = newCommandQueue(cfg.Bridge.CommandQueueSize, func(c *queuedCommand) {})
+	defer b.queue.Stop()
+
+	topic := "loxone/504F94A00000/living-room/blind/command"
+	var results []string
+	mockMQTT.On("Publish", topic+"/result", byte(0), false, mock.Anything).Run(func(args mock.Arguments) {
+		results = append(results, string(args.Get(3).([]byte)))
+	}).Return(nil)
+
+	// Disconnected: both commands are queued, the second supersedes the first
+	mockLox.On("SendCommand", fmt.Sprintf("jdev/sps/io/%s/up", uuidAction)).Return(loxone.ErrNotConnected).Once()
+	b.handleMQTTMessage(&mqtt.Message{Topic: topic, Payload: []byte("up")})
+	b.handleMQTTMessage(&mqtt.Message{Topic: topic, Payload: []byte("stop")})
+	assert.Equal(t, 1, b.queue.Len())
+
+	// Reconnected: the latest intent is replayed
+	mockLox.On("SendCommand", fmt.Sprintf("jdev/sps/io/%s/stop", uuidAction)).Return(nil).Once()
+	b.replayQueue()
+	assert.Equal(t, 0, b.queue.Len())
+
+	require.Len(t, results, 4)
+	assert.Contains(t, results[0], `"queued":true,"command":"up"`)
+	assert.Contains(t, results[1], `"command":"up","error":"superseded by a newer command"`)
+	assert.Contains(t, results[2], `"queued":true,"command":"stop"`)
+	assert.Contains(t, results[3], `"ok":true,"command":"stop"`)
 
 	mockLox.AssertExpectations(t)
 }
@@ -176,9 +440,27 @@ func TestBridge_CommandHandling_Ignored(t *testing.T) {
 	// No expectations on mockLox because it should NOT be called
 
 	// Invalid Topic
-	b.handleMQTTMessage("loxone/other/command", []byte("On"))
+	b.handleMQTTMessage(&mqtt.Message{Topic: "loxone/other/command", Payload: []byte("On")})
 	// Not a command topic
-	b.handleMQTTMessage("loxone/504F94A00000/room/light/state", []byte("On"))
+	b.handleMQTTMessage(&mqtt.Message{Topic: "loxone/504F94A00000/room/light/state", Payload: []byte("On")})
+
+	mockLox.AssertExpectations(t)
+}
+
+func TestBridge_ReconnectLoxone(t *testing.T) {
+	delay := loxoneReconnectDelay
+	loxoneReconnectDelay = time.Millisecond
+	defer func() { loxoneReconnectDelay = delay }()
+
+	mockLox := new(MockLoxoneProvider)
+	b := &Bridge{lox: mockLox, done: make(chan struct{})}
+
+	// Connected but status updates failed: the connection is closed before the next attempt
+	mockLox.On("Connect").Return(nil).Twice()
+	mockLox.On("EnableStatusUpdates").Return(errors.New("timeout")).Once()
+	mockLox.On("Disconnect").Return().Once()
+	mockLox.On("EnableStatusUpdates").Return(nil).Once()
 
+	b.reconnectLoxone(context.Background())
 	mockLox.AssertExpectations(t)
 }
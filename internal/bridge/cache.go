@@ -0,0 +1,35 @@
+package bridge
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// StateCache holds the last published payload of every state UUID
+type StateCache struct {
+	mu     sync.RWMutex
+	values map[uuid.UUID]Payload
+}
+
+// NewStateCache creates an empty cache
+func NewStateCache() *StateCache {
+	return &StateCache{
+		values: make(map[uuid.UUID]Payload),
+	}
+}
+
+// Set stores the latest payload of a state
+func (c *StateCache) Set(u uuid.UUID, p Payload) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[u] = p
+}
+
+// Get returns the latest payload of a state
+func (c *StateCache) Get(u uuid.UUID) (Payload, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	p, ok := c.values[u]
+	return p, ok
+}
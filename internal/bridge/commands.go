@@ -0,0 +1,311 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ArgKind is the type of a command argument
+type ArgKind string
+
+const (
+	ArgNumber  ArgKind = "number"
+	ArgInteger ArgKind = "integer"
+	ArgBool    ArgKind = "bool" // Sent as 1/0
+	ArgText    ArgKind = "text" // URL-escaped
+)
+
+// CommandSpec describes one command of a control type
+type CommandSpec struct {
+	Name string // Empty for bare value commands, e.g. Dimmer "{pos}"
+	Args []ArgKind
+	Call bool // Rendered as name(a,b,c) instead of name/a/b/c
+}
+
+// Usage returns the command in the notation of docs/REFERENCE.md, e.g. "manualPosition/{number}"
+func (s CommandSpec) Usage() string {
+	args := make([]string, len(s.Args))
+	for i, a := range s.Args {
+		args[i] = "{" + string(a) + "}"
+	}
+	if s.Call {
+		return fmt.Sprintf("%s(%s)", s.Name, strings.Join(args, ","))
+	}
+	return strings.Join(append(nameSegments(s.Name), args...), "/")
+}
+
+func nameSegments(name string) []string {
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+func cmd(name string, args ...ArgKind) CommandSpec {
+	return CommandSpec{Name: name, Args: args}
+}
+
+// commandSchemas is keyed by control type, built from the command lists in docs/REFERENCE.md.
+// Types without an entry (or with incomplete lists in the reference) pass commands through unvalidated.
+var commandSchemas = map[string][]CommandSpec{
+	"AalEmergency":  {cmd("trigger"), cmd("quit"), cmd("disable", ArgInteger)},
+	"AalSmartAlarm": {cmd("confirm"), cmd("disable", ArgInteger), cmd("startDrill")},
+	"Alarm": {
+		cmd("on"), cmd("on", ArgInteger), cmd("delayedon"), cmd("delayedon", ArgInteger),
+		cmd("off"), cmd("quit"), cmd("dismv", ArgInteger),
+	},
+	"AlarmChain": {cmd("quit")},
+	"AlarmClock": {cmd("snooze"), cmd("dismiss"), cmd("setSnoozeDuration", ArgInteger)},
+	"AudioZone": {
+		cmd("volume", ArgNumber), cmd("volstep", ArgNumber), cmd("prev"), cmd("next"), cmd("play"),
+		cmd("pause"), cmd("shuffle"), cmd("repeat", ArgInteger), cmd("on"), cmd("off"), cmd("source", ArgInteger),
+	},
+	"AudioZoneV2": {
+		cmd("volUp"), cmd("volDown"), cmd("volume", ArgNumber), cmd("prev"), cmd("next"), cmd("play"), cmd("pause"),
+	},
+	"CarCharger": {cmd("charge/on"), cmd("charge/off"), cmd("limitMode", ArgInteger), cmd("limit", ArgNumber)},
+	"ClimateController": {
+		cmd("setServiceMode", ArgInteger), cmd("ventilation", ArgInteger), cmd("autoMode", ArgInteger),
+		cmd("setHeatingBoundary", ArgNumber), cmd("setCoolingBoundary", ArgNumber),
+	},
+	"ClimateControllerUS": {
+		cmd("ventilation", ArgInteger), cmd("setMode", ArgInteger), cmd("useEmergency", ArgBool),
+		cmd("setMinimumTempCooling", ArgNumber), cmd("setMaximumTempHeating", ArgNumber), cmd("setServiceMode", ArgInteger),
+	},
+	"ColorPickerV2": {
+		cmd("setFav", ArgInteger, ArgText),
+		{Name: "hsv", Args: []ArgKind{ArgNumber, ArgNumber, ArgNumber}, Call: true},
+		{Name: "temp", Args: []ArgKind{ArgNumber, ArgNumber}, Call: true},
+		cmd("setBrightness", ArgNumber),
+	},
+	"Daytimer":    {cmd("pulse"), cmd("default"), cmd("startOverride", ArgNumber, ArgInteger), cmd("stopOverride")},
+	"Dimmer":      {cmd("on"), cmd("off"), cmd("", ArgNumber)},
+	"Gate":        {cmd("open"), cmd("close"), cmd("stop"), cmd("partiallyOpen")},
+	"Hourcounter": {cmd("reset"), cmd("resetAll")},
+	"IRoomControllerV2": {
+		cmd("override", ArgInteger, ArgInteger, ArgNumber), cmd("stopOverride"), cmd("setComfortTemperature", ArgNumber),
+		cmd("setManualTemperature", ArgNumber), cmd("setOperatingMode", ArgInteger),
+	},
+	"Intercom":   {cmd("answer")},
+	"IntercomV2": {cmd("answer"), cmd("mute", ArgBool)},
+	"Jalousie": {
+		cmd("up"), cmd("down"), cmd("FullUp"), cmd("FullDown"), cmd("shade"), cmd("auto"), cmd("NoAuto"),
+		cmd("manualPosition", ArgNumber), cmd("manualLamelle", ArgNumber), cmd("stop"),
+	},
+	"LightControllerV2": {
+		cmd("changeTo", ArgInteger), cmd("addMood", ArgInteger), cmd("removeMood", ArgInteger),
+		cmd("plus"), cmd("minus"), cmd("presence/on"), cmd("presence/off"),
+	},
+	"Meter":            {cmd("reset")},
+	"PresenceDetector": {cmd("", ArgNumber), cmd("time", ArgNumber)},
+	"Pushbutton":       {cmd("on"), cmd("pulse")},
+	"Radio":            {cmd("reset"), cmd("", ArgInteger), cmd("next"), cmd("prev")},
+	"Sauna":            {cmd("on"), cmd("off"), cmd("fanon"), cmd("fanoff"), cmd("temp", ArgNumber)},
+	"SmokeAlarm":       {cmd("mute"), cmd("confirm"), cmd("startDrill")},
+	"Switch":           {cmd("On"), cmd("Off"), cmd("Pulse")},
+	"TextInput":        {cmd("", ArgText)},
+	"TimedSwitch":      {cmd("on"), cmd("off"), cmd("pulse")},
+	"ValueSelector":    {cmd("", ArgNumber)},
+	"Ventilation": {
+		cmd("setTimer", ArgInteger, ArgInteger, ArgInteger, ArgInteger), cmd("setTimer", ArgInteger),
+		cmd("setPresenceMin", ArgNumber), cmd("setPresenceMax", ArgNumber),
+	},
+	"Wallbox2": {cmd("allow/on"), cmd("allow/off"), cmd("setmode", ArgInteger), cmd("manualLimit", ArgNumber)},
+	"Window": {
+		cmd("open/on"), cmd("open/off"), cmd("close/on"), cmd("close/off"), cmd("fullopen"), cmd("fullclose"),
+		cmd("moveToPosition", ArgNumber), cmd("stop"),
+	},
+}
+
+// CommandUsages lists the accepted commands of a control type, nil if the type has no schema
+func CommandUsages(controlType string) []string {
+	specs, ok := commandSchemas[controlType]
+	if !ok {
+		return nil
+	}
+	usages := make([]string, len(specs))
+	for i, s := range specs {
+		usages[i] = s.Usage()
+	}
+	return usages
+}
+
+// Command is the JSON form of a command payload: {"cmd": "manualPosition", "args": [50]}
+type Command struct {
+	Cmd  string        `json:"cmd"`
+	Args []interface{} `json:"args,omitempty"`
+}
+
+// BuildCommand validates an MQTT command payload against the schema of the control type and
+// returns the escaped Loxone command, i.e. the part after jdev/sps/io/<uuid>/.
+// Payloads are either JSON ({"cmd": ..., "args": [...]}) or the raw Loxone notation ("manualPosition/50").
+func BuildCommand(controlType string, payload []byte) (string, error) {
+	raw := strings.TrimSpace(string(payload))
+	if raw == "" {
+		return "", fmt.Errorf("empty command")
+	}
+
+	specs, hasSchema := commandSchemas[controlType]
+
+	if strings.HasPrefix(raw, "{") {
+		var c Command
+		if err := json.Unmarshal([]byte(raw), &c); err != nil {
+			return "", fmt.Errorf("invalid JSON command: %v", err)
+		}
+		if !hasSchema {
+			return renderUnchecked(c)
+		}
+		for _, s := range specs {
+			if strings.EqualFold(s.Name, c.Cmd) && len(s.Args) == len(c.Args) {
+				return s.render(c.Args)
+			}
+		}
+		return "", unknownCommand(controlType, c.Cmd, len(c.Args))
+	}
+
+	if !hasSchema {
+		return escapeSegments(strings.Split(raw, "/")), nil
+	}
+
+	for _, s := range specs {
+		if args, ok := s.match(raw); ok {
+			return s.render(args)
+		}
+	}
+	return "", unknownCommand(controlType, raw, -1)
+}
+
+// match splits a raw command into arguments if it has the spec's name and arity
+func (s CommandSpec) match(raw string) ([]interface{}, bool) {
+	if s.Call {
+		prefix := strings.ToLower(s.Name) + "("
+		if !strings.HasPrefix(strings.ToLower(raw), prefix) || !strings.HasSuffix(raw, ")") {
+			return nil, false
+		}
+		parts := strings.Split(raw[len(prefix):len(raw)-1], ",")
+		return toArgs(parts), len(parts) == len(s.Args)
+	}
+
+	name := nameSegments(s.Name)
+	parts := strings.Split(raw, "/")
+	if len(parts) < len(name) {
+		return nil, false
+	}
+	for i, n := range name {
+		if !strings.EqualFold(parts[i], n) {
+			return nil, false
+		}
+	}
+	args := parts[len(name):]
+
+	// A trailing text argument takes the rest of the payload, slashes included
+	if n := len(s.Args); n > 0 && s.Args[n-1] == ArgText && len(args) >= n {
+		args = append(args[:n-1], strings.Join(args[n-1:], "/"))
+	}
+	return toArgs(args), len(args) == len(s.Args)
+}
+
+func toArgs(parts []string) []interface{} {
+	args := make([]interface{}, len(parts))
+	for i, p := range parts {
+		args[i] = strings.TrimSpace(p)
+	}
+	return args
+}
+
+func (s CommandSpec) render(args []interface{}) (string, error) {
+	formatted := make([]string, len(args))
+	for i, a := range args {
+		v, err := formatArg(s.Args[i], a)
+		if err != nil {
+			return "", fmt.Errorf("command %s: argument %d: %v", s.Usage(), i+1, err)
+		}
+		formatted[i] = v
+	}
+	if s.Call {
+		return fmt.Sprintf("%s(%s)", s.Name, strings.Join(formatted, ",")), nil
+	}
+	return strings.Join(append(nameSegments(s.Name), formatted...), "/"), nil
+}
+
+func formatArg(kind ArgKind, v interface{}) (string, error) {
+	switch kind {
+	case ArgNumber, ArgInteger:
+		var f float64
+		switch val := v.(type) {
+		case float64:
+			f = val
+		case string:
+			parsed, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return "", fmt.Errorf("%q is not a number", val)
+			}
+			f = parsed
+		default:
+			return "", fmt.Errorf("%v is not a number", v)
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", fmt.Errorf("%v is not a finite number", v)
+		}
+		if kind == ArgInteger && f != math.Trunc(f) {
+			return "", fmt.Errorf("%v is not an integer", v)
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	case ArgBool:
+		switch strings.ToLower(fmt.Sprint(v)) {
+		case "true", "1", "on":
+			return "1", nil
+		case "false", "0", "off":
+			return "0", nil
+		}
+		return "", fmt.Errorf("%v is not a boolean", v)
+	case ArgText:
+		s, ok := v.(string)
+		if !ok {
+			s = fmt.Sprint(v)
+		}
+		return url.PathEscape(s), nil
+	}
+	return "", fmt.Errorf("unknown argument kind %s", kind)
+}
+
+// renderUnchecked builds a command for control types without a schema, arguments are only escaped
+func renderUnchecked(c Command) (string, error) {
+	if c.Cmd == "" && len(c.Args) == 0 {
+		return "", fmt.Errorf("empty command")
+	}
+	parts := nameSegments(c.Cmd)
+	for _, a := range c.Args {
+		s, ok := a.(string)
+		if !ok {
+			b, err := json.Marshal(a)
+			if err != nil {
+				return "", err
+			}
+			s = string(b)
+		}
+		parts = append(parts, s)
+	}
+	return escapeSegments(parts), nil
+}
+
+func escapeSegments(parts []string) string {
+	escaped := make([]string, len(parts))
+	for i, p := range parts {
+		escaped[i] = url.PathEscape(p)
+	}
+	return strings.Join(escaped, "/")
+}
+
+func unknownCommand(controlType, command string, args int) error {
+	if args >= 0 {
+		return fmt.Errorf("unknown command %q with %d argument(s) for %s (accepted: %s)",
+			command, args, controlType, strings.Join(CommandUsages(controlType), ", "))
+	}
+	return fmt.Errorf("unknown command %q for %s (accepted: %s)",
+		command, controlType, strings.Join(CommandUsages(controlType), ", "))
+}
@@ -0,0 +1,61 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		typ     string
+		payload string
+		want    string
+		wantErr bool
+	}{
+		{"Raw simple", "Switch", "On", "On", false},
+		{"Raw case-insensitive", "Jalousie", "fullup", "FullUp", false},
+		{"Raw with number", "Jalousie", "manualPosition/50", "manualPosition/50", false},
+		{"JSON with number", "Jalousie", `{"cmd":"manualPosition","args":[50.5]}`, "manualPosition/50.5", false},
+		{"JSON string number", "Jalousie", `{"cmd":"manualPosition","args":["50"]}`, "manualPosition/50", false},
+		{"Multiple args", "Daytimer", `{"cmd":"startOverride","args":[21.5,3600]}`, "startOverride/21.5/3600", false},
+		{"Multi-segment name", "Wallbox2", "allow/on", "allow/on", false},
+		{"Overloaded arity", "Alarm", "on/1", "on/1", false},
+		{"Bare value", "Dimmer", "75", "75", false},
+		{"Bare value JSON", "Dimmer", `{"args":[75]}`, "75", false},
+		{"Call style", "ColorPickerV2", "hsv(120,100,50)", "hsv(120,100,50)", false},
+		{"Call style JSON", "ColorPickerV2", `{"cmd":"temp","args":[80,2700]}`, "temp(80,2700)", false},
+		{"Bool arg", "IntercomV2", `{"cmd":"mute","args":[true]}`, "mute/1", false},
+		{"Text escaped", "TextInput", "hello world/again", "hello%20world%2Fagain", false},
+		{"Text arg after int", "ColorPickerV2", "setFav/1/warm white", "setFav/1/warm%20white", false},
+		{"Unknown command", "Jalousie", "open", "", true},
+		{"Wrong arity", "Jalousie", "manualPosition", "", true},
+		{"Not a number", "Jalousie", "manualPosition/half", "", true},
+		{"Not an integer", "IRoomControllerV2", "setOperatingMode/1.5", "", true},
+		{"Not a finite number", "ValueSelector", "NaN", "", true},
+		{"Invalid JSON", "Jalousie", `{"cmd":`, "", true},
+		{"Empty", "Switch", "  ", "", true},
+		{"No schema passes through escaped", "Remote", "mode/my mode", "mode/my%20mode", false},
+		{"No schema JSON", "Remote", `{"cmd":"mode","args":[2]}`, "mode/2", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BuildCommand(tt.typ, []byte(tt.payload))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCommandUsages(t *testing.T) {
+	assert.Contains(t, CommandUsages("Jalousie"), "manualPosition/{number}")
+	assert.Contains(t, CommandUsages("ColorPickerV2"), "hsv({number},{number},{number})")
+	assert.Contains(t, CommandUsages("Dimmer"), "{number}")
+	assert.Nil(t, CommandUsages("Remote"))
+}
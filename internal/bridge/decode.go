@@ -0,0 +1,291 @@
+package bridge
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of decoders, describing the typed value for consumers that need it up front (e.g. Homie)
+const (
+	KindBool    = "bool"
+	KindTime    = "time"
+	KindEnum    = "enum"
+	KindBitmask = "bitmask"
+)
+
+// Decoder converts a raw Loxone state value into its typed representation
+type Decoder struct {
+	Kind   string
+	names  map[int]string // Values of enum and bitmask decoders
+	decode func(v float64, zone *time.Location) interface{}
+}
+
+// Decode returns the typed value, timestamps in the Miniserver's zone (nil for the system zone)
+func (d Decoder) Decode(v float64, zone *time.Location) interface{} {
+	return d.decode(v, zone)
+}
+
+// Names lists the values of an enum (or the flags of a bitmask) ordered by their number
+func (d Decoder) Names() []string {
+	keys := make([]int, 0, len(d.names))
+	for k := range d.names {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		names = append(names, d.names[k])
+	}
+	return names
+}
+
+// Value returns the number of an enum value, false if the name is unknown
+func (d Decoder) Value(name string) (int, bool) {
+	for k, n := range d.names {
+		if n == name {
+			return k, true
+		}
+	}
+	return 0, false
+}
+
+// loxoneEpoch is the reference point of Loxone timestamps (seconds since 2009-01-01). Loxone
+// counts wall-clock seconds of the Miniserver's zone, so times relative to it carry no offset.
+var loxoneEpoch = time.Date(2009, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// loxoneSeconds returns the Loxone time of t, the wall-clock seconds since 2009-01-01 in t's zone
+func loxoneSeconds(t time.Time) int64 {
+	_, offset := t.Zone()
+	return t.Unix() + int64(offset) - loxoneEpoch.Unix()
+}
+
+// loxoneTime returns the time of a Loxone timestamp, the wall-clock seconds since 2009-01-01
+// in zone. Wall-clock times skipped by a DST change resolve like time.Date.
+func loxoneTime(v float64, zone *time.Location) time.Time {
+	if zone == nil {
+		zone = time.Local
+	}
+	wall := loxoneEpoch.Add(time.Duration(v) * time.Second)
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, zone)
+}
+
+var decodeBool = Decoder{Kind: KindBool, decode: func(v float64, _ *time.Location) interface{} {
+	return v != 0
+}}
+
+var decodeTime = Decoder{Kind: KindTime, decode: func(v float64, zone *time.Location) interface{} {
+	// 0 means "not set" for all timestamp states
+	if v <= 0 {
+		return nil
+	}
+	return loxoneTime(v, zone).Format(time.RFC3339)
+}}
+
+func decodeEnum(names map[int]string) Decoder {
+	return Decoder{Kind: KindEnum, names: names, decode: func(v float64, _ *time.Location) interface{} {
+		if name, ok := names[int(v)]; ok {
+			return name
+		}
+		return nil
+	}}
+}
+
+func decodeBitmask(flags map[int]string) Decoder {
+	bits := make([]int, 0, len(flags))
+	for bit := range flags {
+		bits = append(bits, bit)
+	}
+	sort.Ints(bits)
+
+	return Decoder{Kind: KindBitmask, names: flags, decode: func(v float64, _ *time.Location) interface{} {
+		mask := int(v)
+		set := []string{}
+		for _, bit := range bits {
+			if mask&bit != 0 {
+				set = append(set, flags[bit])
+			}
+		}
+		return set
+	}}
+}
+
+var (
+	connectionStates = decodeEnum(map[int]string{0: "offline", 1: "initializing", 2: "online"})
+	playStates       = decodeEnum(map[int]string{-1: "unknown", 0: "stopped", 1: "paused", 2: "playing"})
+	motionDirections = decodeEnum(map[int]string{-1: "closing", 0: "stopped", 1: "opening"})
+)
+
+// decoders is keyed by control type and state name as listed in docs/REFERENCE.md
+var decoders = map[string]map[string]Decoder{
+	"AalEmergency": {
+		"status":         decodeEnum(map[int]string{0: "running", 1: "alarm_triggered", 2: "reset_input_asserted", 3: "app_disabled"}),
+		"disableEndTime": decodeTime,
+	},
+	"AalSmartAlarm": {
+		"alarmLevel":     decodeEnum(map[int]string{0: "none", 1: "immediate", 2: "delayed"}),
+		"isLocked":       decodeBool,
+		"isLeaveActive":  decodeBool,
+		"disableEndTime": decodeTime,
+	},
+	"Alarm": {
+		"armed":        decodeBool,
+		"nextLevelAt":  decodeTime,
+		"disabledMove": decodeBool,
+		"startTime":    decodeTime,
+	},
+	"AlarmChain": {
+		"activeAlarmType":  decodeBitmask(map[int]string{1: "acknowledged", 2: "alarm", 4: "urgent", 8: "ems"}),
+		"nextAlarmLevelAt": decodeTime,
+	},
+	"AlarmClock": {
+		"isEnabled":          decodeBool,
+		"isAlarmActive":      decodeBool,
+		"confirmationNeeded": decodeBool,
+		"nextEntryTime":      decodeTime,
+	},
+	"AudioZone": {
+		"serverState": decodeEnum(map[int]string{-3: "invalid_zone", -2: "not_reachable", -1: "unknown", 0: "offline", 1: "initializing", 2: "online"}),
+		"playState":   playStates,
+		"clientState": connectionStates,
+		"power":       decodeBool,
+		"shuffle":     decodeBool,
+	},
+	"AudioZoneV2": {
+		"playState":   playStates,
+		"clientState": connectionStates,
+		"power":       decodeBool,
+		"isLocked":    decodeBool,
+	},
+	"CarCharger": {
+		"status":           connectionStates,
+		"charging":         decodeBool,
+		"connected":        decodeBool,
+		"chargingFinished": decodeBool,
+		"limitMode":        decodeEnum(map[int]string{0: "off", 1: "manual", 2: "automatic"}),
+	},
+	"ClimateController": {
+		"currentMode": decodeEnum(map[int]string{0: "no_requirement", 1: "heating", 2: "cooling", 3: "heating_boost", 4: "cooling_boost", 5: "service", 6: "external_heater"}),
+		"autoMode":    decodeEnum(map[int]string{-1: "off", 0: "heating_and_cooling", 1: "heating", 2: "cooling"}),
+	},
+	"ClimateControllerUS": {
+		"mode": decodeEnum(map[int]string{0: "off", 1: "heating_and_cooling", 2: "heating", 3: "cooling"}),
+	},
+	"Daytimer": {
+		"resetActive": decodeBool,
+	},
+	"Gate": {
+		"active":       motionDirections,
+		"preventOpen":  decodeBool,
+		"preventClose": decodeBool,
+	},
+	"Hourcounter": {
+		"lastActivation": decodeTime,
+		"overdue":        decodeBool,
+		"active":         decodeBool,
+	},
+	"InfoOnlyDigital": {
+		"value": decodeBool,
+	},
+	"IRoomControllerV2": {
+		"activeMode":    decodeEnum(map[int]string{0: "eco", 1: "comfort", 2: "building_protection", 3: "manual", 4: "off"}),
+		"operatingMode": decodeEnum(map[int]string{0: "auto_heating_and_cooling", 1: "auto_heating", 2: "auto_cooling", 3: "manual_heating_and_cooling", 4: "manual_heating", 5: "manual_cooling"}),
+		"openWindow":    decodeBool,
+	},
+	"Intercom": {
+		"bell": decodeBool,
+	},
+	"IntercomV2": {
+		"bell": decodeBool,
+	},
+	"Jalousie": {
+		"up":           decodeBool,
+		"down":         decodeBool,
+		"safetyActive": decodeBool,
+		"autoAllowed":  decodeBool,
+		"autoActive":   decodeBool,
+		"locked":       decodeBool,
+	},
+	"LightControllerV2": {
+		"presence": decodeBool,
+	},
+	"NfcCodeTouch": {
+		"codeDate": decodeTime,
+	},
+	"PresenceDetector": {
+		"active":      decodeBool,
+		"locked":      decodeBool,
+		"activeSince": decodeTime,
+	},
+	"Pushbutton": {
+		"active": decodeBool,
+	},
+	"Remote": {
+		"active": decodeBool,
+	},
+	"Sauna": {
+		"active": decodeBool,
+		"power":  decodeBool,
+		"fan":    decodeBool,
+		"drying": decodeBool,
+	},
+	"SmokeAlarm": {
+		"acousticAlarm": decodeBool,
+		"testAlarm":     decodeBool,
+	},
+	"Switch": {
+		"active":   decodeBool,
+		"lockedOn": decodeBool,
+	},
+	"Ventilation": {
+		"presence": decodeBool,
+	},
+	"Wallbox2": {
+		"connected": decodeBool,
+		"enabled":   decodeBool,
+		"active":    decodeBool,
+	},
+	"Window": {
+		"direction": motionDirections,
+	},
+}
+
+// DecoderOf returns the decoder of a state, false if its values are not decoded
+func DecoderOf(controlType, stateName string) (Decoder, bool) {
+	d, ok := decoders[controlType][stateName]
+	return d, ok
+}
+
+// DecodeValue returns the typed representation of a value event, nil if the state has no decoder.
+// Timestamps are RFC 3339 in zone, the Miniserver's zone (nil for the system zone).
+func DecodeValue(controlType, stateName string, v float64, zone *time.Location) interface{} {
+	d, ok := decoders[controlType][stateName]
+	if !ok {
+		return nil
+	}
+	return d.Decode(v, zone)
+}
+
+// freeTextStates hold user-entered text that must never be reinterpreted as JSON
+var freeTextStates = map[string]map[string]bool{
+	"InfoOnlyText": {"text": true},
+	"TextInput":    {"text": true},
+}
+
+// DecodeText returns JSON-bearing text states (jLocked, textAndIcon, AudioZone metadata, ...)
+// as raw JSON so they are embedded into the payload, and any other text unchanged
+func DecodeText(controlType, stateName, text string) interface{} {
+	if freeTextStates[controlType][stateName] {
+		return text
+	}
+
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return text
+	}
+	if !json.Valid([]byte(trimmed)) {
+		return text
+	}
+	return json.RawMessage(trimmed)
+}
@@ -0,0 +1,173 @@
+package bridge
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeValue(t *testing.T) {
+	tests := []struct {
+		name        string
+		controlType string
+		state       string
+		value       float64
+		expected    interface{}
+	}{
+		{
+			name:        "Boolean On",
+			controlType: "Switch",
+			state:       "active",
+			value:       1,
+			expected:    true,
+		},
+		{
+			name:        "Boolean Off",
+			controlType: "Switch",
+			state:       "active",
+			value:       0,
+			expected:    false,
+		},
+		{
+			name:        "Loxone Timestamp",
+			controlType: "PresenceDetector",
+			state:       "activeSince",
+			value:       86400 + 3600 + 0.5,
+			expected:    "2009-01-02T01:00:00Z",
+		},
+		{
+			name:        "Unset Timestamp",
+			controlType: "Alarm",
+			state:       "nextLevelAt",
+			value:       0,
+			expected:    nil,
+		},
+		{
+			name:        "Enumeration",
+			controlType: "AudioZone",
+			state:       "playState",
+			value:       2,
+			expected:    "playing",
+		},
+		{
+			name:        "Negative Enumeration",
+			controlType: "Gate",
+			state:       "active",
+			value:       -1,
+			expected:    "closing",
+		},
+		{
+			name:        "Unknown Enumeration Value",
+			controlType: "AudioZone",
+			state:       "playState",
+			value:       7,
+			expected:    nil,
+		},
+		{
+			name:        "Bitmask",
+			controlType: "AlarmChain",
+			state:       "activeAlarmType",
+			value:       2 + 8,
+			expected:    []string{"alarm", "ems"},
+		},
+		{
+			name:        "Empty Bitmask",
+			controlType: "AlarmChain",
+			state:       "activeAlarmType",
+			value:       0,
+			expected:    []string{},
+		},
+		{
+			name:        "No Decoder",
+			controlType: "InfoOnlyAnalog",
+			state:       "value",
+			value:       21.5,
+			expected:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, DecodeValue(tt.controlType, tt.state, tt.value, time.UTC))
+		})
+	}
+}
+
+func TestDecodeValue_TimeZone(t *testing.T) {
+	zone, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err)
+	ts := func(wall time.Time) float64 {
+		return float64(wall.Sub(loxoneEpoch) / time.Second)
+	}
+
+	// Loxone counts wall-clock seconds of the Miniserver's zone, the offset follows DST
+	assert.Equal(t, "2024-01-15T08:30:00+01:00",
+		DecodeValue("PresenceDetector", "activeSince", ts(time.Date(2024, 1, 15, 8, 30, 0, 0, time.UTC)), zone))
+	assert.Equal(t, "2024-07-15T08:30:00+02:00",
+		DecodeValue("PresenceDetector", "activeSince", ts(time.Date(2024, 7, 15, 8, 30, 0, 0, time.UTC)), zone))
+
+	// Round trip with the times written by the bridge
+	now := time.Date(2024, 10, 4, 13, 0, 0, 0, zone)
+	assert.Equal(t, now.Format(time.RFC3339),
+		DecodeValue("PresenceDetector", "activeSince", float64(loxoneSeconds(now)), zone))
+}
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		name        string
+		controlType string
+		state       string
+		text        string
+		expected    string // JSON encoding of the payload value
+	}{
+		{
+			name:        "JSON Object",
+			controlType: "Switch",
+			state:       "jLocked",
+			text:        `{"locked":true,"reason":"Maintenance"}`,
+			expected:    `{"locked":true,"reason":"Maintenance"}`,
+		},
+		{
+			name:        "JSON Array With Whitespace",
+			controlType: "LightControllerV2",
+			state:       "activeMoods",
+			text:        " [1, 4]\n",
+			expected:    `[1,4]`,
+		},
+		{
+			name:        "Plain Text",
+			controlType: "AudioZone",
+			state:       "songName",
+			text:        "Bohemian Rhapsody",
+			expected:    `"Bohemian Rhapsody"`,
+		},
+		{
+			name:        "Invalid JSON Falls Back To String",
+			controlType: "TextState",
+			state:       "textAndIcon",
+			text:        `{"text": "broken`,
+			expected:    `"{\"text\": \"broken"`,
+		},
+		{
+			name:        "Free Text Stays String",
+			controlType: "TextInput",
+			state:       "text",
+			text:        `[1]`,
+			expected:    `"[1]"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := json.Marshal(Payload{Value: DecodeText(tt.controlType, tt.state, tt.text)})
+			assert.NoError(t, err)
+
+			var decoded map[string]json.RawMessage
+			assert.NoError(t, json.Unmarshal(payload, &decoded))
+			assert.Equal(t, tt.expected, string(decoded["value"]))
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+)
+
+// Payload formats selectable via BRIDGE_PAYLOAD_FORMAT(S)
+const (
+	FormatRaw      = "raw"
+	FormatJSON     = "json"
+	FormatExtended = "extended"
+	FormatTemplate = "template"
+)
+
+// Message is a state value together with the metadata available to payload encoders
+type Message struct {
+	Payload
+	UUID    string
+	Type    string
+	Room    string
+	Control string
+	State   string
+	Index   int
+	Topic   string
+}
+
+// newMessage describes a state payload for the encoders
+func newMessage(s *State, p Payload, topic string) Message {
+	return Message{
+		Payload: p,
+		UUID:    LoxoneUUID(s.UUID),
+		Type:    s.Control.Type,
+		Room:    s.RoomName,
+		Control: s.Control.Name,
+		State:   s.Name,
+		Index:   s.Index,
+		Topic:   topic,
+	}
+}
+
+// PayloadEncoder turns a state message into the MQTT payload
+type PayloadEncoder interface {
+	Encode(m Message) ([]byte, error)
+}
+
+// rawEncoder publishes the bare value, for tools that cannot parse JSON
+type rawEncoder struct{}
+
+func (rawEncoder) Encode(m Message) ([]byte, error) {
+	return []byte(rawString(m.Value)), nil
+}
+
+// envelopeEncoder publishes the Payload envelope: {"value", "typed", "ts"}
+type envelopeEncoder struct{}
+
+func (envelopeEncoder) Encode(m Message) ([]byte, error) {
+	return json.Marshal(m.Payload)
+}
+
+// extendedEncoder adds the state's identity and unit to the envelope
+type extendedEncoder struct{}
+
+type extendedPayload struct {
+	Payload
+	UUID    string `json:"uuid"`
+	Type    string `json:"type"`
+	Room    string `json:"room"`
+	Control string `json:"control"`
+	State   string `json:"state"`
+	Index   *int   `json:"index,omitempty"`
+}
+
+func (extendedEncoder) Encode(m Message) ([]byte, error) {
+	p := extendedPayload{
+		Payload: m.Payload,
+		UUID:    m.UUID,
+		Type:    m.Type,
+		Room:    m.Room,
+		Control: m.Control,
+		State:   m.State,
+	}
+	if m.Index >= 0 {
+		p.Index = &m.Index
+	}
+	return json.Marshal(p)
+}
+
+// templateEncoder renders a user-defined Go text/template with the Message as data
+type templateEncoder struct {
+	tmpl *template.Template
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"raw": rawString,
+}
+
+func newTemplateEncoder(text string) (*templateEncoder, error) {
+	tmpl, err := template.New("payload").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload template: %w", err)
+	}
+	return &templateEncoder{tmpl: tmpl}, nil
+}
+
+func (e *templateEncoder) Encode(m Message) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := e.tmpl.Execute(&buf, m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// rawString formats a payload value without JSON quoting
+func rawString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case json.RawMessage:
+		return string(val)
+	case bool:
+		return strconv.FormatBool(val)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// payloadFormats selects the encoder of a state: "<type>_<state>" class first, then "<type>", then the default
+type payloadFormats struct {
+	def     PayloadEncoder
+	byClass map[string]PayloadEncoder
+}
+
+func newPayloadFormats(cfg config.BridgeConfig) (*payloadFormats, error) {
+	var tmpl PayloadEncoder
+	if cfg.PayloadTemplate != "" {
+		t, err := newTemplateEncoder(cfg.PayloadTemplate)
+		if err != nil {
+			return nil, err
+		}
+		tmpl = t
+	}
+
+	encoderFor := func(format string) (PayloadEncoder, error) {
+		switch strings.ToLower(format) {
+		case FormatRaw:
+			return rawEncoder{}, nil
+		case FormatJSON, "":
+			return envelopeEncoder{}, nil
+		case FormatExtended:
+			return extendedEncoder{}, nil
+		case FormatTemplate:
+			if tmpl == nil {
+				return nil, fmt.Errorf("payload format %q requires BRIDGE_PAYLOAD_TEMPLATE", format)
+			}
+			return tmpl, nil
+		default:
+			return nil, fmt.Errorf("invalid payload format: %s (must be raw, json, extended, or template)", format)
+		}
+	}
+
+	def, err := encoderFor(cfg.PayloadFormat)
+	if err != nil {
+		return nil, err
+	}
+	f := &payloadFormats{
+		def:     def,
+		byClass: make(map[string]PayloadEncoder),
+	}
+	for class, format := range cfg.PayloadFormats {
+		enc, err := encoderFor(format)
+		if err != nil {
+			return nil, fmt.Errorf("payload format for %s: %w", class, err)
+		}
+		f.byClass[sanitize(class)] = enc
+	}
+	return f, nil
+}
+
+// For returns the encoder of a state, nil receivers fall back to the JSON envelope
+func (f *payloadFormats) For(s *State) PayloadEncoder {
+	if f == nil {
+		return envelopeEncoder{}
+	}
+	for _, class := range s.Classes() {
+		if enc, ok := f.byClass[class]; ok {
+			return enc
+		}
+	}
+	return f.def
+}
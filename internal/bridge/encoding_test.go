@@ -0,0 +1,117 @@
+package bridge
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testState(t *testing.T) *State {
+	u, err := ParseUUID("10000000-0000-0000-0000000000000001")
+	require.NoError(t, err)
+	return &State{
+		Control: &loxone.Control{
+			Name:    "Temp Sensor",
+			Type:    "InfoOnlyAnalog",
+			Details: map[string]interface{}{"format": "%.1f°C"},
+		},
+		Name:        "value",
+		UUID:        u,
+		RoomName:    "Living Room",
+		Index:       -1,
+		RoomSlug:    "living-room",
+		ControlSlug: "temp-sensor",
+		StateSlug:   "value",
+	}
+}
+
+func TestPayloadEncoders(t *testing.T) {
+	s := testState(t)
+	m := newMessage(s, Payload{Value: 21.5, Unit: "°C", Text: "21.5°C", Ts: "2024-10-01T12:34:56Z"}, "lox/snr/"+s.Path())
+
+	tests := []struct {
+		name     string
+		encoder  PayloadEncoder
+		expected string
+	}{
+		{
+			name:     "Raw",
+			encoder:  rawEncoder{},
+			expected: "21.5",
+		},
+		{
+			name:     "JSON Envelope",
+			encoder:  envelopeEncoder{},
+			expected: `{"value":21.5,"unit":"°C","text":"21.5°C","ts":"2024-10-01T12:34:56Z"}`,
+		},
+		{
+			name:    "Extended",
+			encoder: extendedEncoder{},
+			expected: `{"value":21.5,"unit":"°C","text":"21.5°C","ts":"2024-10-01T12:34:56Z",` +
+				`"uuid":"10000000-0000-0000-0000000000000001","type":"InfoOnlyAnalog","room":"Living Room","control":"Temp Sensor","state":"value"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.encoder.Encode(m)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, string(out))
+		})
+	}
+
+	t.Run("Template", func(t *testing.T) {
+		enc, err := newTemplateEncoder(`{{.Control}}={{raw .Value}}{{.Unit}} {{json .Payload}}`)
+		require.NoError(t, err)
+		out, err := enc.Encode(m)
+		assert.NoError(t, err)
+		assert.Equal(t, `Temp Sensor=21.5°C {"value":21.5,"unit":"°C","text":"21.5°C","ts":"2024-10-01T12:34:56Z"}`, string(out))
+	})
+
+	t.Run("Raw JSON Text", func(t *testing.T) {
+		out, err := rawEncoder{}.Encode(Message{Payload: Payload{Value: json.RawMessage(`{"a":1}`)}})
+		assert.NoError(t, err)
+		assert.Equal(t, `{"a":1}`, string(out))
+	})
+}
+
+func TestPayloadFormats_Selection(t *testing.T) {
+	s := testState(t)
+
+	formats, err := newPayloadFormats(config.BridgeConfig{
+		PayloadFormat:   "extended",
+		PayloadFormats:  map[string]string{"InfoOnlyAnalog_Value": "raw", "switch": "template"},
+		PayloadTemplate: "{{raw .Value}}",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, rawEncoder{}, formats.For(s))
+
+	s.Control = &loxone.Control{Type: "Switch"}
+	s.Name = "active"
+	assert.IsType(t, &templateEncoder{}, formats.For(s))
+
+	s.Control = &loxone.Control{Type: "Dimmer"}
+	assert.Equal(t, extendedEncoder{}, formats.For(s))
+
+	var unset *payloadFormats
+	assert.Equal(t, envelopeEncoder{}, unset.For(s))
+}
+
+func TestPayloadFormats_Invalid(t *testing.T) {
+	_, err := newPayloadFormats(config.BridgeConfig{PayloadFormat: "xml"})
+	assert.Error(t, err)
+
+	_, err = newPayloadFormats(config.BridgeConfig{PayloadFormat: "template"})
+	assert.Error(t, err)
+
+	_, err = newPayloadFormats(config.BridgeConfig{PayloadFormats: map[string]string{"switch": "yaml"}})
+	assert.Error(t, err)
+
+	_, err = newPayloadFormats(config.BridgeConfig{PayloadFormat: "template", PayloadTemplate: "{{.Value"})
+	assert.Error(t, err)
+}
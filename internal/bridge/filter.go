@@ -0,0 +1,210 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/google/uuid"
+)
+
+// deadband suppresses numeric changes smaller than an absolute or relative threshold
+type deadband struct {
+	abs      float64
+	relative bool // abs is a fraction of the last published value
+}
+
+// parseDeadband accepts "0.5" (absolute) or "2%" (relative to the last published value)
+func parseDeadband(s string) (deadband, error) {
+	s = strings.TrimSpace(s)
+	relative := strings.HasSuffix(s, "%")
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || v < 0 {
+		return deadband{}, fmt.Errorf("invalid deadband %q (use e.g. 0.5 or 2%%)", s)
+	}
+	if relative {
+		v /= 100
+	}
+	return deadband{abs: v, relative: relative}, nil
+}
+
+func (d deadband) within(last, v float64) bool {
+	threshold := d.abs
+	if d.relative {
+		threshold = d.abs * math.Abs(last)
+	}
+	return math.Abs(v-last) <= threshold
+}
+
+type filterEntry struct {
+	last      interface{} // Last published value
+	published time.Time
+	pending   *time.Timer // Trailing-edge flush of a rate-limited change
+	heartbeat *time.Timer
+}
+
+// publishFilter decides which state changes reach the broker. Suppressed changes stay in the
+// StateCache; trailing-edge flushes and heartbeats republish the cached value via flush.
+type publishFilter struct {
+	mu           sync.Mutex
+	dedupe       bool
+	deadbands    map[string]deadband
+	minInterval  time.Duration
+	minIntervals map[string]time.Duration
+	maxAge       time.Duration
+	flush        func(u uuid.UUID)
+	entries      map[uuid.UUID]*filterEntry
+	stopped      bool
+}
+
+// newPublishFilter returns nil if no suppression is configured
+func newPublishFilter(cfg config.BridgeConfig, flush func(u uuid.UUID)) (*publishFilter, error) {
+	if !cfg.PublishDedupe && len(cfg.PublishDeadband) == 0 && cfg.PublishMinInterval == 0 &&
+		len(cfg.PublishMinIntervals) == 0 && cfg.PublishMaxAge == 0 {
+		return nil, nil
+	}
+
+	f := &publishFilter{
+		dedupe:       cfg.PublishDedupe,
+		deadbands:    make(map[string]deadband),
+		minInterval:  cfg.PublishMinInterval,
+		minIntervals: make(map[string]time.Duration),
+		maxAge:       cfg.PublishMaxAge,
+		flush:        flush,
+		entries:      make(map[uuid.UUID]*filterEntry),
+	}
+	for class, s := range cfg.PublishDeadband {
+		d, err := parseDeadband(s)
+		if err != nil {
+			return nil, fmt.Errorf("deadband for %s: %w", class, err)
+		}
+		f.deadbands[sanitize(class)] = d
+	}
+	for class, d := range cfg.PublishMinIntervals {
+		f.minIntervals[sanitize(class)] = d
+	}
+	return f, nil
+}
+
+// Allow reports whether a new value of the state should be published now.
+// A rate-limited change arms a trailing-edge flush so the latest value is never lost.
+func (f *publishFilter) Allow(s *State, value interface{}, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stopped {
+		return false
+	}
+
+	e, ok := f.entries[s.UUID]
+	if !ok {
+		e = &filterEntry{}
+		f.entries[s.UUID] = e
+		f.record(s.UUID, e, value, now)
+		return true
+	}
+
+	if f.unchanged(s, e.last, value) {
+		// A pending flush would publish a value that has returned into the band
+		if e.pending != nil {
+			e.pending.Stop()
+			e.pending = nil
+		}
+		return false
+	}
+
+	if interval := f.intervalFor(s); interval > 0 {
+		if wait := e.published.Add(interval).Sub(now); wait > 0 {
+			if e.pending == nil {
+				e.pending = time.AfterFunc(wait, func() { f.flush(s.UUID) })
+			}
+			return false
+		}
+	}
+
+	f.record(s.UUID, e, value, now)
+	return true
+}
+
+// Record marks a value as published outside of Allow (trailing-edge flush, heartbeat, republish)
+func (f *publishFilter) Record(u uuid.UUID, value interface{}, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stopped {
+		return
+	}
+	e, ok := f.entries[u]
+	if !ok {
+		e = &filterEntry{}
+		f.entries[u] = e
+	}
+	f.record(u, e, value, now)
+}
+
+func (f *publishFilter) record(u uuid.UUID, e *filterEntry, value interface{}, now time.Time) {
+	e.last = value
+	e.published = now
+	if e.pending != nil {
+		e.pending.Stop()
+		e.pending = nil
+	}
+	if f.maxAge > 0 {
+		if e.heartbeat != nil {
+			e.heartbeat.Stop()
+		}
+		e.heartbeat = time.AfterFunc(f.maxAge, func() { f.flush(u) })
+	}
+}
+
+// unchanged applies dedupe and deadband; deadbands imply dedupe for their class
+func (f *publishFilter) unchanged(s *State, last, value interface{}) bool {
+	for _, class := range s.Classes() {
+		if d, ok := f.deadbands[class]; ok {
+			lastNum, ok1 := last.(float64)
+			num, ok2 := value.(float64)
+			if ok1 && ok2 {
+				return d.within(lastNum, num)
+			}
+			return equalValues(last, value)
+		}
+	}
+	return f.dedupe && equalValues(last, value)
+}
+
+func (f *publishFilter) intervalFor(s *State) time.Duration {
+	for _, class := range s.Classes() {
+		if d, ok := f.minIntervals[class]; ok {
+			return d
+		}
+	}
+	return f.minInterval
+}
+
+// Stop cancels all pending flushes and heartbeats
+func (f *publishFilter) Stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = true
+	for _, e := range f.entries {
+		if e.pending != nil {
+			e.pending.Stop()
+		}
+		if e.heartbeat != nil {
+			e.heartbeat.Stop()
+		}
+	}
+}
+
+func equalValues(a, b interface{}) bool {
+	ra, okA := a.(json.RawMessage)
+	rb, okB := b.(json.RawMessage)
+	if okA || okB {
+		return okA && okB && string(ra) == string(rb)
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,129 @@
+package bridge
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type flushRecorder struct {
+	mu      sync.Mutex
+	flushed []uuid.UUID
+}
+
+func (r *flushRecorder) flush(u uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flushed = append(r.flushed, u)
+}
+
+func (r *flushRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.flushed)
+}
+
+func TestParseDeadband(t *testing.T) {
+	d, err := parseDeadband("0.5")
+	require.NoError(t, err)
+	assert.True(t, d.within(20, 20.5))
+	assert.False(t, d.within(20, 20.6))
+
+	d, err = parseDeadband("10%")
+	require.NoError(t, err)
+	assert.True(t, d.within(200, 219))
+	assert.False(t, d.within(200, 221))
+
+	for _, invalid := range []string{"", "abc", "-1", "%"} {
+		_, err := parseDeadband(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestPublishFilter_Disabled(t *testing.T) {
+	f, err := newPublishFilter(config.BridgeConfig{}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+
+	_, err = newPublishFilter(config.BridgeConfig{PublishDeadband: map[string]string{"meter": "x"}}, nil)
+	assert.Error(t, err)
+}
+
+func TestPublishFilter_DedupeAndDeadband(t *testing.T) {
+	rec := &flushRecorder{}
+	f, err := newPublishFilter(config.BridgeConfig{
+		PublishDedupe:   true,
+		PublishDeadband: map[string]string{"InfoOnlyAnalog": "0.5"},
+	}, rec.flush)
+	require.NoError(t, err)
+	defer f.Stop()
+
+	s := testState(t)
+	now := time.Now()
+
+	assert.True(t, f.Allow(s, 20.0, now))
+	assert.False(t, f.Allow(s, 20.0, now), "duplicate")
+	assert.False(t, f.Allow(s, 20.4, now), "within deadband")
+	assert.True(t, f.Allow(s, 20.6, now))
+	// The band is relative to the last published value, not the last received one
+	assert.False(t, f.Allow(s, 20.2, now))
+	assert.True(t, f.Allow(s, 20.0, now))
+
+	text := testState(t)
+	text.Control.Type = "TextState"
+	text.UUID = uuid.New()
+	assert.True(t, f.Allow(text, "a", now))
+	assert.False(t, f.Allow(text, "a", now))
+	assert.True(t, f.Allow(text, "b", now))
+}
+
+func TestPublishFilter_MinIntervalTrailingEdge(t *testing.T) {
+	rec := &flushRecorder{}
+	f, err := newPublishFilter(config.BridgeConfig{
+		PublishMinIntervals: map[string]time.Duration{"infoonlyanalog_value": 30 * time.Millisecond},
+	}, rec.flush)
+	require.NoError(t, err)
+	defer f.Stop()
+
+	s := testState(t)
+	start := time.Now()
+	assert.True(t, f.Allow(s, 1.0, start))
+	assert.False(t, f.Allow(s, 2.0, start.Add(5*time.Millisecond)))
+	assert.False(t, f.Allow(s, 3.0, start.Add(10*time.Millisecond)))
+
+	// One trailing flush for the whole burst
+	assert.Eventually(t, func() bool { return rec.count() == 1 }, time.Second, 5*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+	assert.Equal(t, 1, rec.count())
+	assert.Equal(t, s.UUID, rec.flushed[0])
+
+	// After the interval changes pass immediately
+	assert.True(t, f.Allow(s, 4.0, start.Add(time.Second)))
+}
+
+func TestPublishFilter_MaxAgeHeartbeat(t *testing.T) {
+	rec := &flushRecorder{}
+	f, err := newPublishFilter(config.BridgeConfig{
+		PublishDedupe: true,
+		PublishMaxAge: 20 * time.Millisecond,
+	}, rec.flush)
+	require.NoError(t, err)
+
+	s := testState(t)
+	assert.True(t, f.Allow(s, 1.0, time.Now()))
+	assert.Eventually(t, func() bool { return rec.count() == 1 }, time.Second, 5*time.Millisecond)
+
+	// The bridge records the republish, which rearms the heartbeat
+	f.Record(s.UUID, 1.0, time.Now())
+	assert.Eventually(t, func() bool { return rec.count() == 2 }, time.Second, 5*time.Millisecond)
+
+	f.Stop()
+	f.Record(s.UUID, 1.0, time.Now())
+	time.Sleep(40 * time.Millisecond)
+	assert.Equal(t, 2, rec.count())
+}
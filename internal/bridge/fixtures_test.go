@@ -0,0 +1,48 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/stretchr/testify/require"
+)
+
+// testLight is the switch of the test structures: "Living Room/<name>", topic living-room/light
+// for the name "Light"
+func testLight(name string) *loxone.Control {
+	return &loxone.Control{Name: name, Room: "r1", Type: "Switch", UUIDAction: "20000000-0000-0000-0000000000000001",
+		States: map[string]interface{}{"active": "10000000-0000-0000-0000000000000001"}}
+}
+
+// testStructure builds a structure with the room "Living Room" (r1) and the given controls,
+// keyed by their uuidAction
+func testStructure(controls ...*loxone.Control) *loxone.LoxApp3 {
+	structure := &loxone.LoxApp3{
+		MsInfo:   map[string]interface{}{"msName": "Home"},
+		Rooms:    map[string]*loxone.Room{"r1": {Name: "Living Room", UUID: "r1"}},
+		Controls: make(map[string]*loxone.Control, len(controls)),
+	}
+	for _, ctrl := range controls {
+		structure.Controls[ctrl.UUIDAction] = ctrl
+	}
+	return structure
+}
+
+// newTestBridge builds a bridge for the Miniserver 504F94A00000 below the topic prefix "loxone",
+// with mocked clients, a state cache and the registry of structure. Optional components
+// (discovery, Homie, ...) are set by the test.
+func newTestBridge(t *testing.T, structure *loxone.LoxApp3, bridgeCfg config.BridgeConfig) (*Bridge, *MockMQTTProvider, *MockLoxoneProvider) {
+	mockMQTT := new(MockMQTTProvider)
+	mockLox := new(MockLoxoneProvider)
+	cfg := &config.Config{
+		Loxone: config.LoxoneConfig{Snr: "504F94A00000"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "loxone"},
+		Bridge: bridgeCfg,
+	}
+	formats, err := newPayloadFormats(cfg.Bridge)
+	require.NoError(t, err)
+	b := &Bridge{cfg: cfg, lox: mockLox, mqtt: mockMQTT, formats: formats, cache: NewStateCache(),
+		registry: NewRegistry(structure, nil)}
+	return b, mockMQTT, mockLox
+}
@@ -0,0 +1,127 @@
+package bridge
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+)
+
+// ValueFormat is a parsed Loxone printf-style format string such as "%.1f°C" or "%.0f kWh"
+type ValueFormat struct {
+	spec      string // Go verb incl. flags, width and precision, e.g. "%.1f"
+	prefix    string
+	suffix    string
+	Precision int // Decimals the value is rounded to, -1 to keep full precision
+	Unit      string
+}
+
+var formatVerb = regexp.MustCompile(`^%[-+ #0]*\d*(\.\d+)?[dfis]`)
+
+// ParseValueFormat parses a Loxone format string, false if it contains no supported verb
+func ParseValueFormat(format string) (*ValueFormat, bool) {
+	var literal strings.Builder
+	f := &ValueFormat{Precision: -1}
+	found := false
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			literal.WriteByte(format[i])
+			continue
+		}
+		if i+1 < len(format) && format[i+1] == '%' {
+			literal.WriteByte('%')
+			i++
+			continue
+		}
+		m := formatVerb.FindStringSubmatch(format[i:])
+		if m == nil || found {
+			return nil, false
+		}
+		found = true
+		f.prefix = literal.String()
+		literal.Reset()
+
+		spec := m[0]
+		switch verb := spec[len(spec)-1]; verb {
+		case 'd', 'i':
+			f.Precision = 0
+			spec = spec[:len(spec)-1] + "d"
+		case 'f':
+			f.Precision = 6
+			if m[1] != "" {
+				f.Precision, _ = strconv.Atoi(m[1][1:])
+			}
+		}
+		f.spec = spec
+		i += len(m[0]) - 1
+	}
+	if !found {
+		return nil, false
+	}
+	f.suffix = literal.String()
+
+	f.Unit = strings.TrimSpace(f.suffix)
+	if f.Unit == "" {
+		f.Unit = strings.TrimSpace(f.prefix)
+	}
+	return f, true
+}
+
+// Round rounds the value to the precision shown by the Loxone app
+func (f *ValueFormat) Round(v float64) float64 {
+	if f.Precision < 0 {
+		return v
+	}
+	p := math.Pow10(f.Precision)
+	return math.Round(v*p) / p
+}
+
+// Text renders the value like the Loxone app does
+func (f *ValueFormat) Text(v float64) string {
+	var value string
+	switch f.spec[len(f.spec)-1] {
+	case 'd':
+		value = fmt.Sprintf(f.spec, int64(math.Round(v)))
+	case 's':
+		value = fmt.Sprintf(f.spec, strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		// Round first: fmt rounds half to even, the Loxone app rounds half away from zero
+		value = fmt.Sprintf(f.spec, f.Round(v))
+	}
+	return f.prefix + value + f.suffix
+}
+
+// formatScopes limits the shared details.format to the states it describes, e.g. the
+// IRC format "%.1f°" applies to temperatures but not to co2 or humidity
+var formatScopes = map[string]func(stateName string) bool{
+	"IRoomControllerV2": func(stateName string) bool {
+		return strings.Contains(strings.ToLower(stateName), "temp")
+	},
+}
+
+// formatOf returns the display format of a state: details.<state>Format (e.g. Meter actualFormat),
+// falling back to details.format for numeric states
+func formatOf(ctrl *loxone.Control, stateName string) *ValueFormat {
+	if s, ok := ctrl.Details[stateName+"Format"].(string); ok {
+		if f, ok := ParseValueFormat(s); ok {
+			return f
+		}
+	}
+
+	if _, decoded := decoders[ctrl.Type][stateName]; decoded {
+		return nil
+	}
+	if inScope, ok := formatScopes[ctrl.Type]; ok && !inScope(stateName) {
+		return nil
+	}
+	if s, ok := ctrl.Details["format"].(string); ok {
+		if f, ok := ParseValueFormat(s); ok {
+			return f
+		}
+	}
+	return nil
+}
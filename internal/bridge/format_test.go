@@ -0,0 +1,78 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseValueFormat(t *testing.T) {
+	tests := []struct {
+		format    string
+		value     float64
+		rounded   float64
+		unit      string
+		text      string
+		precision int
+	}{
+		{format: "%.1f°C", value: 21.4567, rounded: 21.5, unit: "°C", text: "21.5°C", precision: 1},
+		{format: "%.0f kWh", value: 1234.5, rounded: 1235, unit: "kWh", text: "1235 kWh", precision: 0},
+		{format: "%.2f%%", value: 12.3456, rounded: 12.35, unit: "%", text: "12.35%", precision: 2},
+		{format: "%d W", value: 149.6, rounded: 150, unit: "W", text: "150 W", precision: 0},
+		{format: "%i", value: 3.2, rounded: 3, unit: "", text: "3", precision: 0},
+		{format: "CHF %.2f", value: 0.255, rounded: 0.26, unit: "CHF", text: "CHF 0.26", precision: 2},
+		{format: "%f", value: 1.5, rounded: 1.5, unit: "", text: "1.500000", precision: 6},
+		{format: "%s lx", value: 12.25, rounded: 12.25, unit: "lx", text: "12.25 lx", precision: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			f, ok := ParseValueFormat(tt.format)
+			require.True(t, ok)
+			assert.Equal(t, tt.precision, f.Precision)
+			assert.InDelta(t, tt.rounded, f.Round(tt.value), 1e-9)
+			assert.Equal(t, tt.unit, f.Unit)
+			assert.Equal(t, tt.text, f.Text(tt.value))
+		})
+	}
+
+	for _, invalid := range []string{"", "°C", "<v.u>", "%.1f / %.1f", "%x"} {
+		_, ok := ParseValueFormat(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	meter := &loxone.Control{
+		Type:    "Meter",
+		Details: map[string]interface{}{"actualFormat": "%.3f kW", "totalFormat": "%.1f kWh"},
+	}
+	assert.Equal(t, "kW", formatOf(meter, "actual").Unit)
+	assert.Equal(t, "kWh", formatOf(meter, "total").Unit)
+
+	irc := &loxone.Control{
+		Type:    "IRoomControllerV2",
+		Details: map[string]interface{}{"format": "%.1f°"},
+	}
+	assert.NotNil(t, formatOf(irc, "tempActual"))
+	assert.Nil(t, formatOf(irc, "co2"))
+	// Decoded states (enum, bool) are not formatted
+	assert.Nil(t, formatOf(irc, "activeMode"))
+
+	assert.Nil(t, formatOf(&loxone.Control{Type: "Switch"}, "active"))
+}
+
+func TestNewPayload_Format(t *testing.T) {
+	s := testState(t)
+	s.Format = formatOf(s.Control, s.Name)
+	require.NotNil(t, s.Format)
+
+	p := newPayload(s, 21.4567, nil)
+	assert.Equal(t, 21.4567, p.Value, "value stays unrounded")
+	require.NotNil(t, p.Rounded)
+	assert.Equal(t, 21.5, *p.Rounded)
+	assert.Equal(t, "°C", p.Unit)
+	assert.Equal(t, "21.5°C", p.Text)
+}
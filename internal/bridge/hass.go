@@ -0,0 +1,479 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+)
+
+// hassDiscovery publishes Home Assistant MQTT discovery configs derived from the registry
+type hassDiscovery struct {
+	mu        sync.Mutex
+	prefix    string          // Discovery prefix, "homeassistant" by default
+	published map[string]bool // Config topics of the current entities
+}
+
+func newHassDiscovery(prefix string) *hassDiscovery {
+	if prefix == "" {
+		prefix = "homeassistant"
+	}
+	return &hassDiscovery{prefix: prefix, published: make(map[string]bool)}
+}
+
+// hassEntity is one Home Assistant entity of a control
+type hassEntity struct {
+	component string // e.g. switch, light, cover
+	suffix    string // Distinguishes several entities of one control, empty for the main entity
+	name      string // Appended to the control name, empty for the main entity
+	config    map[string]interface{}
+}
+
+// hassControl gives the mappers access to the topics and value expressions of a control
+type hassControl struct {
+	ctrl    *loxone.Control
+	base    string // <prefix>/<snr>/<room>/<control>
+	states  map[string]*State
+	formats *payloadFormats
+}
+
+// has reports whether the control has all states with a payload format HA can parse
+func (c *hassControl) has(names ...string) bool {
+	for _, name := range names {
+		s, ok := c.states[name]
+		if !ok {
+			return false
+		}
+		if _, ok := hassValue(c.formats.For(s)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// topic returns the state topic of a state
+func (c *hassControl) topic(name string) string {
+	return c.base + "/" + c.states[name].Segment()
+}
+
+// setTopic returns the set topic of a writable state
+func (c *hassControl) setTopic(name string) string {
+	return c.topic(name) + "/set"
+}
+
+func (c *hassControl) commandTopic() string {
+	return c.base + "/command"
+}
+
+// template renders a value template, %s is replaced by the expression of the state's value
+func (c *hassControl) template(name, format string) string {
+	expr, _ := hassValue(c.formats.For(c.states[name]))
+	return fmt.Sprintf(format, expr)
+}
+
+// unit returns the unit of a state from its display format, empty if unknown
+func (c *hassControl) unit(name string) string {
+	if s, ok := c.states[name]; ok && s.Format != nil {
+		return s.Format.Unit
+	}
+	return ""
+}
+
+// hassValue returns the template expression of the value for a payload encoder,
+// false for user-defined templates whose structure is unknown
+func hassValue(enc PayloadEncoder) (string, bool) {
+	switch enc.(type) {
+	case rawEncoder:
+		return "value", true
+	case envelopeEncoder, extendedEncoder:
+		return "value_json.value", true
+	}
+	return "", false
+}
+
+// hassDeviceClasses derives the sensor device class from the unit of a display format
+var hassDeviceClasses = map[string]string{
+	"°C":  "temperature",
+	"°F":  "temperature",
+	"W":   "power",
+	"kW":  "power",
+	"Wh":  "energy",
+	"kWh": "energy",
+}
+
+// hassSensor builds a sensor of a numeric state with its unit
+func hassSensor(c *hassControl, state, stateClass string) map[string]interface{} {
+	cfg := map[string]interface{}{
+		"state_topic":    c.topic(state),
+		"value_template": c.template(state, "{{ %s }}"),
+		"state_class":    stateClass,
+	}
+	if unit := c.unit(state); unit != "" {
+		cfg["unit_of_measurement"] = unit
+		if class, ok := hassDeviceClasses[unit]; ok {
+			// Energy sensors must count up, measurements of energy are not valid in HA
+			if class != "energy" || stateClass == "total_increasing" {
+				cfg["device_class"] = class
+			}
+		}
+	}
+	return cfg
+}
+
+// hassMappers is keyed by control type. Positions are inverted where Loxone counts
+// 0 = open and HA 100 = open.
+var hassMappers = map[string]func(c *hassControl) []hassEntity{
+	"Alarm": func(c *hassControl) []hassEntity {
+		if !c.has("armed") {
+			return nil
+		}
+		return []hassEntity{{component: "alarm_control_panel", config: map[string]interface{}{
+			"state_topic":          c.topic("armed"),
+			"value_template":       c.template("armed", "{{ 'armed_away' if %s | int == 1 else 'disarmed' }}"),
+			"command_topic":        c.commandTopic(),
+			"payload_arm_away":     "on",
+			"payload_disarm":       "off",
+			"code_arm_required":    false,
+			"code_disarm_required": false,
+			"supported_features":   []string{"arm_away"},
+		}}}
+	},
+	"Dimmer": func(c *hassControl) []hassEntity {
+		if !c.has("position") {
+			return nil
+		}
+		return []hassEntity{{component: "light", config: map[string]interface{}{
+			"command_topic":             c.commandTopic(),
+			"payload_on":                "on",
+			"payload_off":               "off",
+			"state_topic":               c.topic("position"),
+			"state_value_template":      c.template("position", "{{ 'on' if %s | float(0) > 0 else 'off' }}"),
+			"brightness_state_topic":    c.topic("position"),
+			"brightness_value_template": c.template("position", "{{ %s | float(0) | round(0) | int }}"),
+			"brightness_command_topic":  c.setTopic("position"),
+			"brightness_scale":          100,
+			"on_command_type":           "brightness",
+		}}}
+	},
+	"Gate": func(c *hassControl) []hassEntity {
+		if !c.has("position") {
+			return nil
+		}
+		return []hassEntity{{component: "cover", config: map[string]interface{}{
+			"device_class":      "garage",
+			"command_topic":     c.commandTopic(),
+			"payload_open":      "open",
+			"payload_close":     "close",
+			"payload_stop":      "stop",
+			"position_topic":    c.topic("position"),
+			"position_template": c.template("position", "{{ (%s | float(0) * 100) | round(0) | int }}"),
+		}}}
+	},
+	"InfoOnlyAnalog": func(c *hassControl) []hassEntity {
+		if !c.has("value") {
+			return nil
+		}
+		return []hassEntity{{component: "sensor", config: hassSensor(c, "value", "measurement")}}
+	},
+	"InfoOnlyDigital": func(c *hassControl) []hassEntity {
+		if !c.has("value") {
+			return nil
+		}
+		return []hassEntity{{component: "binary_sensor", config: map[string]interface{}{
+			"state_topic":    c.topic("value"),
+			"value_template": c.template("value", "{{ %s | int }}"),
+			"payload_on":     "1",
+			"payload_off":    "0",
+		}}}
+	},
+	"IRoomControllerV2": func(c *hassControl) []hassEntity {
+		if !c.has("tempActual", "tempTarget") {
+			return nil
+		}
+		cfg := map[string]interface{}{
+			"current_temperature_topic":    c.topic("tempActual"),
+			"current_temperature_template": c.template("tempActual", "{{ %s }}"),
+			"temperature_state_topic":      c.topic("tempTarget"),
+			"temperature_state_template":   c.template("tempTarget", "{{ %s }}"),
+			"temperature_command_topic":    c.setTopic("tempTarget"),
+			"temperature_unit":             "C",
+			"precision":                    0.1,
+		}
+		if c.has("operatingMode") {
+			cfg["modes"] = []string{"auto", "heat_cool", "heat", "cool"}
+			cfg["mode_state_topic"] = c.topic("operatingMode")
+			cfg["mode_state_template"] = c.template("operatingMode",
+				"{{ ['auto', 'auto', 'auto', 'heat_cool', 'heat', 'cool'][%s | int] }}")
+			// Automatic mode heats and cools, the manual modes map one to one (setOperatingMode)
+			cfg["mode_command_topic"] = c.setTopic("operatingMode")
+			cfg["mode_command_template"] = "{{ {'auto': 0, 'heat_cool': 3, 'heat': 4, 'cool': 5}[value] }}"
+		}
+		return []hassEntity{{component: "climate", config: cfg}}
+	},
+	"Jalousie": func(c *hassControl) []hassEntity {
+		if !c.has("position") {
+			return nil
+		}
+		cfg := map[string]interface{}{
+			"device_class":          "shutter",
+			"command_topic":         c.commandTopic(),
+			"payload_open":          "FullUp",
+			"payload_close":         "FullDown",
+			"payload_stop":          "stop",
+			"position_topic":        c.topic("position"),
+			"position_template":     c.template("position", "{{ (100 - %s | float(0) * 100) | round(0) | int }}"),
+			"set_position_topic":    c.setTopic("position"),
+			"set_position_template": "{{ (100 - position) / 100 }}",
+		}
+		if c.has("shadePosition") {
+			cfg["tilt_status_topic"] = c.topic("shadePosition")
+			cfg["tilt_status_template"] = c.template("shadePosition", "{{ (100 - %s | float(0) * 100) | round(0) | int }}")
+			cfg["tilt_command_topic"] = c.setTopic("shadePosition")
+			cfg["tilt_command_template"] = "{{ (100 - tilt_position) / 100 }}"
+		}
+		return []hassEntity{{component: "cover", config: cfg}}
+	},
+	"Meter": func(c *hassControl) []hassEntity {
+		var entities []hassEntity
+		if c.has("actual") {
+			entities = append(entities, hassEntity{component: "sensor", suffix: "actual", name: "Actual",
+				config: hassSensor(c, "actual", "measurement")})
+		}
+		if c.has("total") {
+			entities = append(entities, hassEntity{component: "sensor", suffix: "total", name: "Total",
+				config: hassSensor(c, "total", "total_increasing")})
+		}
+		return entities
+	},
+	"PresenceDetector": func(c *hassControl) []hassEntity {
+		if !c.has("active") {
+			return nil
+		}
+		return []hassEntity{{component: "binary_sensor", config: map[string]interface{}{
+			"device_class":   "occupancy",
+			"state_topic":    c.topic("active"),
+			"value_template": c.template("active", "{{ %s | int }}"),
+			"payload_on":     "1",
+			"payload_off":    "0",
+		}}}
+	},
+	"Pushbutton": func(c *hassControl) []hassEntity {
+		return []hassEntity{{component: "button", config: map[string]interface{}{
+			"command_topic": c.commandTopic(),
+			"payload_press": "pulse",
+		}}}
+	},
+	"SmokeAlarm": func(c *hassControl) []hassEntity {
+		if !c.has("level") {
+			return nil
+		}
+		return []hassEntity{{component: "binary_sensor", config: map[string]interface{}{
+			"device_class":   "smoke",
+			"state_topic":    c.topic("level"),
+			"value_template": c.template("level", "{{ 'ON' if %s | int > 0 else 'OFF' }}"),
+		}}}
+	},
+	"Switch": func(c *hassControl) []hassEntity {
+		if !c.has("active") {
+			return nil
+		}
+		return []hassEntity{{component: "switch", config: map[string]interface{}{
+			"state_topic":    c.topic("active"),
+			"value_template": c.template("active", "{{ %s | int }}"),
+			"state_on":       "1",
+			"state_off":      "0",
+			"command_topic":  c.setTopic("active"),
+			"payload_on":     "1",
+			"payload_off":    "0",
+		}}}
+	},
+	"Window": func(c *hassControl) []hassEntity {
+		if !c.has("position") {
+			return nil
+		}
+		return []hassEntity{{component: "cover", config: map[string]interface{}{
+			"device_class":          "window",
+			"command_topic":         c.commandTopic(),
+			"payload_open":          "fullopen",
+			"payload_close":         "fullclose",
+			"payload_stop":          "stop",
+			"position_topic":        c.topic("position"),
+			"position_template":     c.template("position", "{{ (%s | float(0) * 100) | round(0) | int }}"),
+			"set_position_topic":    c.setTopic("position"),
+			"set_position_template": "{{ position / 100 }}",
+		}}}
+	},
+}
+
+// hassNode is the node ID of the bridge's configs: <discovery-prefix>/<component>/<node>/<object>/config
+func (b *Bridge) hassNode() string {
+	return "lox_" + b.cfg.Loxone.Snr
+}
+
+// hassConfigs builds the discovery configs of all mapped controls, keyed by config topic
+func (b *Bridge) hassConfigs() map[string][]byte {
+	availability := []map[string]string{{"topic": b.statusTopic()}}
+	if b.homieWill() {
+		// The will moved to the Homie $state, _status only turns offline on a clean shutdown
+		availability = append(availability, map[string]string{
+			"topic": b.homie.base() + "/$state", "payload_available": HomieReady, "payload_not_available": HomieLost,
+		})
+	}
+	configs := make(map[string][]byte)
+
+	for ctrl, path := range b.reg().controlPaths {
+		mapper, ok := hassMappers[ctrl.Type]
+		if !ok {
+			continue
+		}
+		action, err := ParseUUID(ctrl.UUIDAction)
+		if err != nil {
+			continue
+		}
+
+		c := &hassControl{
+			ctrl:    ctrl,
+			base:    fmt.Sprintf("%s/%s/%s", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr, path),
+			states:  make(map[string]*State),
+			formats: b.formats,
+		}
+		for _, s := range b.reg().StatesOf(ctrl) {
+			if s.Index < 0 {
+				s := s
+				c.states[s.Name] = &s
+			}
+		}
+
+		room := b.reg().RoomOf(ctrl)
+		device := map[string]interface{}{
+			"identifiers":  []string{fmt.Sprintf("%s_%s", b.hassNode(), ctrl.Room)},
+			"name":         room,
+			"manufacturer": "Loxone",
+			"model":        "Room",
+		}
+		if room == "" {
+			device["identifiers"] = []string{b.hassNode()}
+			device["name"] = "Loxone Miniserver"
+			device["model"] = "Miniserver"
+		} else {
+			device["suggested_area"] = room
+		}
+
+		for _, e := range mapper(c) {
+			objectID := LoxoneUUID(action)
+			name := ctrl.Name
+			if e.suffix != "" {
+				objectID += "_" + e.suffix
+				name += " " + e.name
+			}
+
+			e.config["name"] = name
+			e.config["unique_id"] = fmt.Sprintf("%s_%s", b.hassNode(), objectID)
+			e.config["device"] = device
+			e.config["availability"] = availability
+			e.config["availability_mode"] = "all"
+
+			payload, err := json.Marshal(e.config)
+			if err != nil {
+				slog.Error("Failed to encode discovery config", "control", ctrl.Name, "error", err)
+				continue
+			}
+			topic := fmt.Sprintf("%s/%s/%s/%s/config", b.hass.prefix, e.component, b.hassNode(), objectID)
+			configs[topic] = payload
+		}
+	}
+	return configs
+}
+
+// publishDiscovery publishes the configs of the current registry and removes the entities
+// of controls that no longer exist
+func (b *Bridge) publishDiscovery() {
+	if b.hass == nil || b.reg() == nil {
+		return
+	}
+	configs := b.hassConfigs()
+
+	// Swap the published set first, the lock must not be held while publishing
+	// since handleDiscoveryMessage takes it on the MQTT client's goroutine
+	topics := make([]string, 0, len(configs))
+	published := make(map[string]bool, len(configs))
+	for topic := range configs {
+		topics = append(topics, topic)
+		published[topic] = true
+	}
+	sort.Strings(topics)
+
+	var removed []string
+	b.hass.mu.Lock()
+	for topic := range b.hass.published {
+		if !published[topic] {
+			removed = append(removed, topic)
+		}
+	}
+	b.hass.published = published
+	b.hass.mu.Unlock()
+
+	for _, topic := range topics {
+		if err := b.mqtt.Publish(topic, 1, true, configs[topic]); err != nil {
+			slog.Error("Failed to publish discovery config", "topic", topic, "error", err)
+		}
+	}
+	for _, topic := range removed {
+		b.removeDiscovery(topic)
+	}
+	slog.Info("Published Home Assistant discovery", "entities", len(configs))
+}
+
+// withdraw returns the published config topics and forgets them, so they can be removed
+func (h *hassDiscovery) withdraw() map[string]bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	topics := h.published
+	h.published = make(map[string]bool)
+	return topics
+}
+
+// removeDiscovery deletes an entity in Home Assistant with an empty retained config
+func (b *Bridge) removeDiscovery(topic string) {
+	slog.Info("Removing Home Assistant entity", "topic", topic)
+	if err := b.mqtt.Publish(topic, 1, true, []byte{}); err != nil {
+		slog.Error("Failed to remove discovery config", "topic", topic, "error", err)
+	}
+}
+
+// startDiscovery publishes the configs, then subscribes to the bridge's config topics to remove
+// retained entities of earlier runs, and to the HA status to republish when HA restarts
+func (b *Bridge) startDiscovery() error {
+	b.publishDiscovery()
+
+	configFilter := fmt.Sprintf("%s/+/%s/+/config", b.hass.prefix, b.hassNode())
+	if err := b.mqtt.Subscribe(configFilter, 1, b.handleDiscoveryMessage); err != nil {
+		return err
+	}
+	return b.mqtt.Subscribe(b.hass.prefix+"/status", 1, b.handleDiscoveryMessage)
+}
+
+// handleDiscoveryMessage runs on the MQTT client's goroutine, publishing is done asynchronously
+func (b *Bridge) handleDiscoveryMessage(msg *mqtt.Message) {
+	if msg.Topic == b.hass.prefix+"/status" {
+		if string(msg.Payload) == StatusOnline {
+			slog.Info("Home Assistant started, republishing discovery")
+			go b.publishDiscovery()
+		}
+		return
+	}
+
+	if len(msg.Payload) == 0 {
+		return
+	}
+	b.hass.mu.Lock()
+	current := b.hass.published[msg.Topic]
+	b.hass.mu.Unlock()
+	if !current {
+		go b.removeDiscovery(msg.Topic)
+	}
+}
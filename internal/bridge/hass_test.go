@@ -0,0 +1,187 @@
+package bridge
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newHassBridge builds a test bridge with discovery below the prefix "homeassistant"
+func newHassBridge(t *testing.T, structure *loxone.LoxApp3, bridgeCfg config.BridgeConfig) (*Bridge, *MockMQTTProvider) {
+	b, mockMQTT, _ := newTestBridge(t, structure, bridgeCfg)
+	b.hass = newHassDiscovery("homeassistant")
+	return b, mockMQTT
+}
+
+// hassStructure has a control of every mapped kind, a control without a room and an unmapped one
+func hassStructure() *loxone.LoxApp3 {
+	return testStructure(
+		testLight("Light"),
+		&loxone.Control{Name: "Blind", Room: "r1", Type: "Jalousie", UUIDAction: "20000000-0000-0000-0000000000000002",
+			States: map[string]interface{}{
+				"position":      "10000000-0000-0000-0000000000000002",
+				"shadePosition": "10000000-0000-0000-0000000000000003",
+			}},
+		&loxone.Control{Name: "Power", Room: "r1", Type: "Meter", UUIDAction: "20000000-0000-0000-0000000000000003",
+			Details: map[string]interface{}{"actualFormat": "%.1fkW", "totalFormat": "%.1fkWh"},
+			States: map[string]interface{}{
+				"actual": "10000000-0000-0000-0000000000000004",
+				"total":  "10000000-0000-0000-0000000000000005",
+			}},
+		&loxone.Control{Name: "Outdoor", Type: "InfoOnlyAnalog", UUIDAction: "20000000-0000-0000-0000000000000004",
+			Details: map[string]interface{}{"format": "%.1f°C"},
+			States:  map[string]interface{}{"value": "10000000-0000-0000-0000000000000006"}},
+		&loxone.Control{Name: "Scene", Room: "r1", Type: "LightControllerV2", UUIDAction: "20000000-0000-0000-0000000000000005"},
+	)
+}
+
+func TestBridge_HassConfigs(t *testing.T) {
+	b, _ := newHassBridge(t, hassStructure(), config.BridgeConfig{
+		PayloadFormats: map[string]string{"infoonlyanalog": FormatRaw},
+	})
+
+	configs := b.hassConfigs()
+	require.Len(t, configs, 5, "switch, cover, two meter sensors and a sensor; LightControllerV2 is not mapped")
+
+	decode := func(topic string) map[string]interface{} {
+		raw, ok := configs[topic]
+		require.True(t, ok, topic)
+		var cfg map[string]interface{}
+		require.NoError(t, json.Unmarshal(raw, &cfg))
+		return cfg
+	}
+
+	sw := decode("homeassistant/switch/lox_504F94A00000/20000000-0000-0000-0000000000000001/config")
+	assert.Equal(t, "Light", sw["name"])
+	assert.Equal(t, "lox_504F94A00000_20000000-0000-0000-0000000000000001", sw["unique_id"])
+	assert.Equal(t, "loxone/504F94A00000/living-room/light/switch_active", sw["state_topic"])
+	assert.Equal(t, "loxone/504F94A00000/living-room/light/switch_active/set", sw["command_topic"])
+	assert.Equal(t, "{{ value_json.value | int }}", sw["value_template"])
+	assert.Equal(t, "all", sw["availability_mode"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"topic": "loxone/504F94A00000/_status"},
+	}, sw["availability"])
+	device := sw["device"].(map[string]interface{})
+	assert.Equal(t, "Living Room", device["name"])
+	assert.Equal(t, "Living Room", device["suggested_area"])
+	assert.Equal(t, []interface{}{"lox_504F94A00000_r1"}, device["identifiers"])
+
+	cover := decode("homeassistant/cover/lox_504F94A00000/20000000-0000-0000-0000000000000002/config")
+	assert.Equal(t, "loxone/504F94A00000/living-room/blind/jalousie_position", cover["position_topic"])
+	assert.Equal(t, "loxone/504F94A00000/living-room/blind/jalousie_shadeposition/set", cover["tilt_command_topic"])
+	assert.Equal(t, "FullUp", cover["payload_open"])
+
+	total := decode("homeassistant/sensor/lox_504F94A00000/20000000-0000-0000-0000000000000003_total/config")
+	assert.Equal(t, "Power Total", total["name"])
+	assert.Equal(t, "kWh", total["unit_of_measurement"])
+	assert.Equal(t, "energy", total["device_class"])
+	assert.Equal(t, "total_increasing", total["state_class"])
+	actual := decode("homeassistant/sensor/lox_504F94A00000/20000000-0000-0000-0000000000000003_actual/config")
+	assert.Equal(t, "power", actual["device_class"])
+
+	// Raw payloads are parsed without value_json, controls without a room belong to the Miniserver
+	sensor := decode("homeassistant/sensor/lox_504F94A00000/20000000-0000-0000-0000000000000004/config")
+	assert.Equal(t, "{{ value }}", sensor["value_template"])
+	assert.Equal(t, "°C", sensor["unit_of_measurement"])
+	assert.Equal(t, "temperature", sensor["device_class"])
+	assert.Equal(t, "Loxone Miniserver", sensor["device"].(map[string]interface{})["name"])
+}
+
+func TestBridge_HassTemplateFormatSkipped(t *testing.T) {
+	b, _ := newHassBridge(t, hassStructure(), config.BridgeConfig{
+		PayloadTemplate: "{{ .Value }}",
+		PayloadFormats:  map[string]string{"switch": FormatTemplate},
+	})
+	for topic := range b.hassConfigs() {
+		assert.NotContains(t, topic, "/switch/")
+	}
+}
+
+func TestBridge_HassClimateMode(t *testing.T) {
+	states := map[string]interface{}{
+		"tempActual": "10000000-0000-0000-0000000000000011",
+		"tempTarget": "10000000-0000-0000-0000000000000012",
+	}
+	structure := testStructure(&loxone.Control{Name: "Heating", Room: "r1", Type: "IRoomControllerV2",
+		UUIDAction: "20000000-0000-0000-0000000000000011", States: states})
+	topic := "homeassistant/climate/lox_504F94A00000/20000000-0000-0000-0000000000000011/config"
+
+	b, _ := newHassBridge(t, structure, config.BridgeConfig{})
+	var climate map[string]interface{}
+	require.NoError(t, json.Unmarshal(b.hassConfigs()[topic], &climate))
+	assert.NotContains(t, climate, "modes", "no mode selector without the operating mode")
+	assert.NotContains(t, climate, "mode_command_topic")
+
+	states["operatingMode"] = "10000000-0000-0000-0000000000000013"
+	b, _ = newHassBridge(t, structure, config.BridgeConfig{})
+	climate = nil
+	require.NoError(t, json.Unmarshal(b.hassConfigs()[topic], &climate))
+	assert.Equal(t, []interface{}{"auto", "heat_cool", "heat", "cool"}, climate["modes"])
+	assert.Equal(t, "loxone/504F94A00000/living-room/heating/iroomcontrollerv2_operatingmode", climate["mode_state_topic"])
+	assert.Equal(t, "loxone/504F94A00000/living-room/heating/iroomcontrollerv2_operatingmode/set", climate["mode_command_topic"])
+	assert.Equal(t, "{{ {'auto': 0, 'heat_cool': 3, 'heat': 4, 'cool': 5}[value] }}", climate["mode_command_template"])
+
+	// Every selectable mode maps back to itself
+	names := map[string]string{"auto": "auto_heating_and_cooling", "heat_cool": "manual_heating_and_cooling",
+		"heat": "manual_heating", "cool": "manual_cooling"}
+	for mode, value := range map[string]float64{"auto": 0, "heat_cool": 3, "heat": 4, "cool": 5} {
+		assert.Equal(t, names[mode], DecodeValue("IRoomControllerV2", "operatingMode", value, nil), mode)
+	}
+}
+
+func TestBridge_HassAvailabilityWithHomie(t *testing.T) {
+	b, _ := newHassBridge(t, hassStructure(), config.BridgeConfig{})
+	b.homie = newHomieDevice("homie", 4, b.cfg.Loxone.Snr)
+	topic := "homeassistant/switch/lox_504F94A00000/20000000-0000-0000-0000000000000001/config"
+
+	var sw map[string]interface{}
+	require.NoError(t, json.Unmarshal(b.hassConfigs()[topic], &sw))
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"topic": "loxone/504F94A00000/_status"},
+	}, sw["availability"], "_status keeps the will")
+
+	b.cfg.Bridge.HomieWill = true
+	require.NoError(t, json.Unmarshal(b.hassConfigs()[topic], &sw))
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"topic": "loxone/504F94A00000/_status"},
+		map[string]interface{}{"topic": "homie/lox-504f94a00000/$state", "payload_available": "ready", "payload_not_available": "lost"},
+	}, sw["availability"], "the Homie $state carries the will")
+}
+
+func TestBridge_HassCleanup(t *testing.T) {
+	b, mockMQTT := newHassBridge(t, hassStructure(), config.BridgeConfig{})
+
+	stale := "homeassistant/switch/lox_504F94A00000/20000000-0000-0000-0000000000000099/config"
+	current := "homeassistant/switch/lox_504F94A00000/20000000-0000-0000-0000000000000001/config"
+	removed := make(chan struct{})
+	mockMQTT.On("Publish", stale, byte(1), true, []byte{}).Return(nil).Run(func(mock.Arguments) { close(removed) }).Once()
+	mockMQTT.On("Publish", mock.Anything, byte(1), true, mock.Anything).Return(nil)
+	mockMQTT.On("Subscribe", "homeassistant/+/lox_504F94A00000/+/config", byte(1), mock.Anything).Return(nil)
+	mockMQTT.On("Subscribe", "homeassistant/status", byte(1), mock.Anything).Return(nil)
+	require.NoError(t, b.startDiscovery())
+	mockMQTT.AssertNumberOfCalls(t, "Publish", 5)
+
+	// A retained config of an earlier run is removed, current ones are kept
+	b.handleDiscoveryMessage(&mqtt.Message{Topic: current, Payload: []byte("{}"), Retained: true})
+	b.handleDiscoveryMessage(&mqtt.Message{Topic: stale, Payload: []byte("{}"), Retained: true})
+	select {
+	case <-removed:
+	case <-time.After(time.Second):
+		t.Fatal("stale config not removed")
+	}
+
+	// Controls removed from the structure are removed on the next publish
+	structure := hassStructure()
+	delete(structure.Controls, "20000000-0000-0000-0000000000000001")
+	b.registry = NewRegistry(structure, nil)
+	mockMQTT.Calls = nil
+	b.publishDiscovery()
+	mockMQTT.AssertCalled(t, "Publish", current, byte(1), true, []byte{})
+}
@@ -0,0 +1,416 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+	"github.com/google/uuid"
+)
+
+// Homie device states, see https://homieiot.github.io/specification/
+const (
+	HomieInit         = "init"
+	HomieReady        = "ready"
+	HomieDisconnected = "disconnected"
+	HomieLost         = "lost"
+)
+
+// homieTextStates are states published as text events, Homie needs their datatype up front
+var homieTextStates = map[string]bool{
+	"text":        true,
+	"textAndIcon": true,
+	"jLocked":     true,
+}
+
+// homieProperty is a state of a control
+type homieProperty struct {
+	ID       string `json:"-"`
+	Name     string `json:"name"`
+	Datatype string `json:"datatype"`
+	Format   string `json:"format,omitempty"`
+	Unit     string `json:"unit,omitempty"`
+	Settable bool   `json:"settable,omitempty"`
+
+	topic   string // Value topic
+	state   *State
+	decoder *Decoder // Enum decoder to translate set values, nil otherwise
+}
+
+// homieNode is a control
+type homieNode struct {
+	ID         string                    `json:"-"`
+	Name       string                    `json:"name"`
+	Type       string                    `json:"type"`
+	Properties map[string]*homieProperty `json:"properties"`
+}
+
+// homieDevice maps the Miniserver to a Homie device, built from the registry in Start
+// and rebuilt when the structure is reloaded
+type homieDevice struct {
+	prefix  string // homie
+	version int    // 4 or 5
+	id      string
+
+	mu      sync.RWMutex
+	nodes   map[string]*homieNode
+	byState map[uuid.UUID]*homieProperty
+	byTopic map[string]*homieProperty // Key: "<node>/<property>"
+}
+
+func newHomieDevice(prefix string, version int, snr string) *homieDevice {
+	if prefix == "" {
+		prefix = "homie"
+	}
+	if version != 5 {
+		version = 4
+	}
+	return &homieDevice{prefix: prefix, version: version, id: homieID("lox-" + snr)}
+}
+
+// base is the device topic: <prefix>/<device> for Homie 4, <prefix>/5/<device> for Homie 5
+func (h *homieDevice) base() string {
+	if h.version == 5 {
+		return fmt.Sprintf("%s/5/%s", h.prefix, h.id)
+	}
+	return fmt.Sprintf("%s/%s", h.prefix, h.id)
+}
+
+// homieID converts a slug into a Homie ID: lowercase a-z, 0-9 and hyphens, not starting with a hyphen
+func homieID(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	return strings.TrimLeft(b.String(), "-")
+}
+
+// homieDatatype derives datatype, format and unit of a state from its decoder and display format
+func homieDatatype(s *State) (datatype, format, unit string) {
+	if d, ok := DecoderOf(s.Control.Type, s.Name); ok {
+		switch d.Kind {
+		case KindBool:
+			return "boolean", "", ""
+		case KindEnum:
+			return "enum", strings.Join(d.Names(), ","), ""
+		case KindTime:
+			return "datetime", "", ""
+		default:
+			return "string", "", ""
+		}
+	}
+	if homieTextStates[s.Name] || freeTextStates[s.Control.Type][s.Name] {
+		return "string", "", ""
+	}
+	if s.Format != nil {
+		if s.Format.Precision == 0 {
+			return "integer", "", s.Format.Unit
+		}
+		return "float", "", s.Format.Unit
+	}
+	return "float", "", ""
+}
+
+// build creates the nodes and properties of all controls in the registry
+func (h *homieDevice) build(r *Registry) {
+	nodes := make(map[string]*homieNode)
+	byState := make(map[uuid.UUID]*homieProperty)
+	byTopic := make(map[string]*homieProperty)
+
+	for ctrl, path := range r.controlPaths {
+		node := &homieNode{
+			ID:         homieID(path),
+			Name:       ctrl.Name,
+			Type:       ctrl.Type,
+			Properties: make(map[string]*homieProperty),
+		}
+		if room := r.RoomOf(ctrl); room != "" {
+			node.Name = fmt.Sprintf("%s (%s)", ctrl.Name, room)
+		}
+
+		for _, s := range r.StatesOf(ctrl) {
+			s := s
+			p := &homieProperty{ID: homieID(s.StateSlug), Name: s.Name, state: &s}
+			p.topic = fmt.Sprintf("%s/%s/%s", h.base(), node.ID, p.ID)
+			if s.Index >= 0 {
+				p.Name = fmt.Sprintf("%s %d", s.Name, s.Index)
+			}
+			p.Datatype, p.Format, p.Unit = homieDatatype(&s)
+			_, p.Settable = SetterOf(&s)
+			if d, ok := DecoderOf(ctrl.Type, s.Name); ok && d.Kind == KindEnum {
+				p.decoder = &d
+			}
+
+			node.Properties[p.ID] = p
+			byState[s.UUID] = p
+			byTopic[node.ID+"/"+p.ID] = p
+		}
+		nodes[node.ID] = node
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nodes, h.byState, h.byTopic = nodes, byState, byTopic
+}
+
+// property returns the property of a state
+func (h *homieDevice) property(u uuid.UUID) (*homieProperty, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	p, ok := h.byState[u]
+	return p, ok
+}
+
+// built reports whether the device was built, i.e. its description was published
+func (h *homieDevice) built() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.nodes != nil
+}
+
+// topics returns the retained topics of the device: description, property values and $state
+func (h *homieDevice) topics() map[string]bool {
+	topics := map[string]bool{h.base() + "/$state": true}
+	for _, msg := range h.attributes("") {
+		topics[msg.Topic] = true
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, p := range h.byState {
+		topics[p.topic] = true
+	}
+	return topics
+}
+
+// propertyAt returns the property at "<node>/<property>"
+func (h *homieDevice) propertyAt(path string) (*homieProperty, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	p, ok := h.byTopic[path]
+	return p, ok
+}
+
+// nodeIDs returns the node IDs in a stable order
+func (h *homieDevice) nodeIDs() []string {
+	ids := make([]string, 0, len(h.nodes))
+	for id := range h.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func propertyIDs(n *homieNode) []string {
+	ids := make([]string, 0, len(n.Properties))
+	for id := range n.Properties {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// attributes returns the retained description messages of the device
+func (h *homieDevice) attributes(name string) []*mqtt.Message {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	base := h.base()
+	var msgs []*mqtt.Message
+	add := func(topic, value string) {
+		msgs = append(msgs, &mqtt.Message{Topic: topic, Payload: []byte(value), QoS: 1, Retained: true})
+	}
+
+	if h.version == 5 {
+		desc := map[string]interface{}{
+			"homie": "5.0",
+			"name":  name,
+			"nodes": h.nodes,
+		}
+		nodes, _ := json.Marshal(h.nodes)
+		desc["version"] = crc32.ChecksumIEEE(nodes)
+		payload, _ := json.Marshal(desc)
+		add(base+"/$description", string(payload))
+		return msgs
+	}
+
+	ids := h.nodeIDs()
+	add(base+"/$homie", "4.0.0")
+	add(base+"/$name", name)
+	add(base+"/$extensions", "")
+	add(base+"/$nodes", strings.Join(ids, ","))
+	for _, id := range ids {
+		node := h.nodes[id]
+		nodeBase := base + "/" + id
+		props := propertyIDs(node)
+		add(nodeBase+"/$name", node.Name)
+		add(nodeBase+"/$type", node.Type)
+		add(nodeBase+"/$properties", strings.Join(props, ","))
+		for _, pid := range props {
+			p := node.Properties[pid]
+			propBase := nodeBase + "/" + pid
+			add(propBase+"/$name", p.Name)
+			add(propBase+"/$datatype", p.Datatype)
+			if p.Format != "" {
+				add(propBase+"/$format", p.Format)
+			}
+			if p.Unit != "" {
+				add(propBase+"/$unit", p.Unit)
+			}
+			if p.Settable {
+				add(propBase+"/$settable", "true")
+			}
+		}
+	}
+	return msgs
+}
+
+// homieValue formats a payload for the datatype of a property, false if the value does not fit
+func homieValue(p *homieProperty, payload Payload) (string, bool) {
+	switch p.Datatype {
+	case "boolean":
+		if b, ok := payload.Typed.(bool); ok {
+			return strconv.FormatBool(b), true
+		}
+		b, err := toBool(payload.Value)
+		return strconv.FormatBool(b), err == nil
+	case "enum", "datetime":
+		s, ok := payload.Typed.(string)
+		return s, ok
+	case "integer", "float":
+		v, ok := payload.Value.(float64)
+		if !ok {
+			return "", false
+		}
+		if payload.Rounded != nil {
+			v = *payload.Rounded
+		}
+		if p.Datatype == "integer" {
+			return strconv.FormatFloat(v, 'f', 0, 64), true
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		if set, ok := payload.Typed.([]string); ok {
+			return strings.Join(set, ","), true
+		}
+		return rawString(payload.Value), true
+	}
+}
+
+// homieMessages returns the property value message of a state, nil if Homie is disabled
+// or the value can't be represented
+func (b *Bridge) homieMessages(state *State, payload Payload) []*mqtt.Message {
+	if b.homie == nil {
+		return nil
+	}
+	p, ok := b.homie.property(state.UUID)
+	if !ok {
+		return nil
+	}
+	value, ok := homieValue(p, payload)
+	if !ok {
+		return nil
+	}
+	return []*mqtt.Message{{Topic: p.topic, Payload: []byte(value), QoS: 1, Retained: true}}
+}
+
+// publishHomieState publishes the device's $state
+func (b *Bridge) publishHomieState(state string) {
+	if b.homie == nil {
+		return
+	}
+	if err := b.mqtt.Publish(b.homie.base()+"/$state", 1, true, state); err != nil {
+		slog.Error("Failed to publish Homie state", "state", state, "error", err)
+	}
+}
+
+// restoreHomieState publishes $state again after a reconnect, replacing the "lost" the broker
+// published as will with BRIDGE_HOMIE_WILL
+func (b *Bridge) restoreHomieState() {
+	if b.homie == nil || !b.homie.built() {
+		return // The first connect, Start publishes the description and $state
+	}
+	if b.loxoneConnected.Load() {
+		b.publishHomieState(HomieReady)
+	} else {
+		b.publishHomieState(HomieLost)
+	}
+}
+
+// startHomie publishes the device description and subscribes to the set topics of its properties
+func (b *Bridge) startHomie(msInfo map[string]interface{}) error {
+	b.publishHomie(msInfo)
+	return b.mqtt.Subscribe(b.homie.base()+"/+/+/set", 1, b.handleHomieSet)
+}
+
+// publishHomie builds the device from the registry and publishes its description
+func (b *Bridge) publishHomie(msInfo map[string]interface{}) {
+	b.homie.build(b.reg())
+
+	b.publishHomieState(HomieInit)
+	for _, msg := range b.homie.attributes(homieName(msInfo, b.cfg.Loxone.Snr)) {
+		if err := b.sendMessage(msg); err != nil {
+			slog.Error("Failed to publish Homie attribute", "topic", msg.Topic, "error", err)
+		}
+	}
+	// The Loxone connection is up after the structure was fetched
+	b.publishHomieState(HomieReady)
+	slog.Info("Published Homie device", "device", b.homie.id)
+}
+
+// handleHomieSet writes a value to a settable property. Unlike set topics there is no result
+// topic, which Homie does not define; outcomes are logged and audited.
+func (b *Bridge) handleHomieSet(msg *mqtt.Message) {
+	parts := strings.Split(strings.TrimPrefix(msg.Topic, b.homie.base()+"/"), "/")
+	if len(parts) != 3 {
+		return
+	}
+	p, ok := b.homie.propertyAt(parts[0] + "/" + parts[1])
+	if !ok || !p.Settable {
+		slog.Warn("Homie set for unknown or read-only property", "topic", msg.Topic)
+		b.audit(msg, nil, time.Now(), CommandResult{Error: "unknown property", code: AuditUnknownControl})
+		return
+	}
+
+	started := time.Now()
+	cmd, err := b.homieCommand(p, string(msg.Payload))
+	if err != nil {
+		slog.Warn("Rejected invalid Homie value", "control", p.state.Control.Name, "state", p.state.Name, "error", err)
+		b.audit(msg, p.state.Control, started, CommandResult{Error: err.Error(), code: AuditInvalid})
+		return
+	}
+	result := b.execCommand(msg, p.state.Control, []byte(cmd))
+	b.audit(msg, p.state.Control, started, result)
+}
+
+// homieCommand translates a Homie value into the command of the property's setter
+func (b *Bridge) homieCommand(p *homieProperty, raw string) (string, error) {
+	setter, _ := SetterOf(p.state)
+	var value interface{} = strings.TrimSpace(raw)
+	if p.decoder != nil {
+		n, ok := p.decoder.Value(raw)
+		if !ok {
+			return "", fmt.Errorf("%q is not one of %s", raw, p.Format)
+		}
+		value = float64(n)
+	}
+	return setter(setRequest{Value: value, Now: b.loxoneNow(), TempOverride: b.cfg.Bridge.TempOverride})
+}
+
+// homieName is the device name: the Miniserver name, or its serial number if unknown
+func homieName(msInfo map[string]interface{}, snr string) string {
+	if name, ok := msInfo["msName"].(string); ok && name != "" {
+		return name
+	}
+	return "Loxone Miniserver " + snr
+}
@@ -0,0 +1,158 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// homieStructure adds a controller with a formatted value and an enum state to the test light
+func homieStructure() *loxone.LoxApp3 {
+	return testStructure(
+		testLight("Light"),
+		&loxone.Control{Name: "Heating", Room: "r1", Type: "IRoomControllerV2", UUIDAction: "20000000-0000-0000-0000000000000002",
+			Details: map[string]interface{}{"format": "%.1f°"},
+			States: map[string]interface{}{
+				"tempActual":    "10000000-0000-0000-0000000000000002",
+				"operatingMode": "10000000-0000-0000-0000000000000003",
+			}},
+	)
+}
+
+// newHomieBridge builds a test bridge publishing a Homie device of the given convention version
+func newHomieBridge(t *testing.T, version int) (*Bridge, *MockMQTTProvider, *MockLoxoneProvider) {
+	b, mockMQTT, mockLox := newTestBridge(t, homieStructure(), config.BridgeConfig{})
+	b.homie = newHomieDevice("homie", version, b.cfg.Loxone.Snr)
+	return b, mockMQTT, mockLox
+}
+
+func TestHomieDatatype(t *testing.T) {
+	r := NewRegistry(homieStructure(), nil)
+	h := newHomieDevice("homie", 4, "504F94A00000")
+	h.build(r)
+
+	node, ok := h.nodes["living-room-heating"]
+	require.True(t, ok)
+	assert.Equal(t, "Heating (Living Room)", node.Name)
+	assert.Equal(t, "IRoomControllerV2", node.Type)
+
+	temp := node.Properties["tempactual"]
+	assert.Equal(t, "float", temp.Datatype)
+	assert.Equal(t, "°", temp.Unit)
+	assert.False(t, temp.Settable)
+
+	mode := node.Properties["operatingmode"]
+	assert.Equal(t, "enum", mode.Datatype)
+	assert.Equal(t, "auto_heating_and_cooling,auto_heating,auto_cooling,manual_heating_and_cooling,manual_heating,manual_cooling", mode.Format)
+	assert.True(t, mode.Settable)
+
+	active := h.nodes["living-room-light"].Properties["active"]
+	assert.Equal(t, "boolean", active.Datatype)
+	assert.True(t, active.Settable)
+	assert.Equal(t, "homie/lox-504f94a00000/living-room-light/active", active.topic)
+}
+
+func TestBridge_Homie(t *testing.T) {
+	b, mockMQTT, mockLox := newHomieBridge(t, 4)
+	base := "homie/lox-504f94a00000"
+
+	mockMQTT.On("Publish", mock.Anything, byte(1), true, mock.Anything).Return(nil)
+	mockMQTT.On("Subscribe", base+"/+/+/set", byte(1), mock.Anything).Return(nil)
+	require.NoError(t, b.startHomie(homieStructure().MsInfo))
+
+	// init first, ready last
+	var publishes []mock.Call
+	for _, c := range mockMQTT.Calls {
+		if c.Method == "Publish" {
+			publishes = append(publishes, c)
+		}
+	}
+	require.NotEmpty(t, publishes)
+	assert.Equal(t, base+"/$state", publishes[0].Arguments.String(0))
+	assert.Equal(t, HomieInit, publishes[0].Arguments.Get(3))
+	assert.Equal(t, HomieReady, publishes[len(publishes)-1].Arguments.Get(3))
+
+	mockMQTT.AssertCalled(t, "Publish", base+"/$homie", byte(1), true, []byte("4.0.0"))
+	mockMQTT.AssertCalled(t, "Publish", base+"/$name", byte(1), true, []byte("Home"))
+	mockMQTT.AssertCalled(t, "Publish", base+"/$nodes", byte(1), true, []byte("living-room-heating,living-room-light"))
+	mockMQTT.AssertCalled(t, "Publish", base+"/living-room-light/active/$datatype", byte(1), true, []byte("boolean"))
+	mockMQTT.AssertCalled(t, "Publish", base+"/living-room-light/active/$settable", byte(1), true, []byte("true"))
+
+	// Values follow the state topics
+	formats, err := newPayloadFormats(b.cfg.Bridge)
+	require.NoError(t, err)
+	b.formats = formats
+	mockMQTT.On("Publish", mock.Anything, byte(0), true, mock.Anything).Return(nil)
+	b.handleEvent(loxone.Event{UUID: "10000000-0000-0000-0000000000000001", Value: 1})
+	mockMQTT.AssertCalled(t, "Publish", base+"/living-room-light/active", byte(1), true, []byte("true"))
+	b.handleEvent(loxone.Event{UUID: "10000000-0000-0000-0000000000000003", Value: 4})
+	mockMQTT.AssertCalled(t, "Publish", base+"/living-room-heating/operatingmode", byte(1), true, []byte("manual_heating"))
+
+	// Set topics translate Homie values, enums by name
+	mockLox.On("SendCommand", fmt.Sprintf("jdev/sps/io/%s/Off", "20000000-0000-0000-0000000000000001")).Return(nil).Once()
+	b.handleHomieSet(&mqtt.Message{Topic: base + "/living-room-light/active/set", Payload: []byte("false")})
+	mockLox.On("SendCommand", fmt.Sprintf("jdev/sps/io/%s/setOperatingMode/4", "20000000-0000-0000-0000000000000002")).Return(nil).Once()
+	b.handleHomieSet(&mqtt.Message{Topic: base + "/living-room-heating/operatingmode/set", Payload: []byte("manual_heating")})
+
+	// Invalid enum values and read-only properties are rejected
+	b.handleHomieSet(&mqtt.Message{Topic: base + "/living-room-heating/operatingmode/set", Payload: []byte("turbo")})
+	b.handleHomieSet(&mqtt.Message{Topic: base + "/living-room-heating/tempactual/set", Payload: []byte("21")})
+	mockLox.AssertExpectations(t)
+}
+
+func TestBridge_HomieV5(t *testing.T) {
+	b, mockMQTT, _ := newHomieBridge(t, 5)
+	base := "homie/5/lox-504f94a00000"
+
+	var description []byte
+	mockMQTT.On("Publish", base+"/$description", byte(1), true, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		description = args.Get(3).([]byte)
+	})
+	mockMQTT.On("Publish", base+"/$state", byte(1), true, mock.Anything).Return(nil)
+	mockMQTT.On("Subscribe", base+"/+/+/set", byte(1), mock.Anything).Return(nil)
+	require.NoError(t, b.startHomie(nil))
+
+	var desc struct {
+		Homie   string `json:"homie"`
+		Name    string `json:"name"`
+		Version uint32 `json:"version"`
+		Nodes   map[string]struct {
+			Type       string `json:"type"`
+			Properties map[string]struct {
+				Datatype string `json:"datatype"`
+				Settable bool   `json:"settable"`
+			} `json:"properties"`
+		} `json:"nodes"`
+	}
+	require.NoError(t, json.Unmarshal(description, &desc))
+	assert.Equal(t, "5.0", desc.Homie)
+	assert.Equal(t, "Loxone Miniserver 504F94A00000", desc.Name)
+	assert.NotZero(t, desc.Version)
+	assert.Equal(t, "Switch", desc.Nodes["living-room-light"].Type)
+	assert.True(t, desc.Nodes["living-room-light"].Properties["active"].Settable)
+
+	b.publishHomieState(HomieLost)
+	mockMQTT.AssertCalled(t, "Publish", base+"/$state", byte(1), true, HomieLost)
+}
+
+func TestBridge_HomieStateAfterReconnect(t *testing.T) {
+	b, mockMQTT, _ := newHomieBridge(t, 4)
+
+	// The first connect, before Start published the device
+	b.restoreHomieState()
+	mockMQTT.AssertNotCalled(t, "Publish", "homie/lox-504f94a00000/$state", byte(1), true, mock.Anything)
+
+	// The broker published the will while the bridge was away
+	b.homie.build(b.reg())
+	b.loxoneConnected.Store(true)
+	mockMQTT.On("Publish", "homie/lox-504f94a00000/$state", byte(1), true, HomieReady).Return(nil).Once()
+	b.restoreHomieState()
+	mockMQTT.AssertExpectations(t)
+}
@@ -2,6 +2,7 @@ package bridge
 
 import (
 	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
 )
 
 // LoxoneProvider defines the interface required from the Loxone client.
@@ -11,6 +12,9 @@ type LoxoneProvider interface {
 	EnableStatusUpdates() error
 	SendCommand(cmd string) error
 	GetEvents() <-chan loxone.Event
+	ConnectionState() <-chan bool
+	// Disconnect drops the connection, reported on ConnectionState like a lost one
+	Disconnect()
 	Close()
 }
 
@@ -18,6 +22,11 @@ type LoxoneProvider interface {
 type MQTTProvider interface {
 	Connect() error
 	Publish(topic string, qos byte, retained bool, payload interface{}) error
-	Subscribe(topic string, qos byte, callback func(topic string, payload []byte)) error
+	// PublishMessage publishes with MQTT v5 properties, v3 clients drop the properties
+	PublishMessage(msg *mqtt.Message) error
+	Subscribe(topic string, qos byte, callback func(msg *mqtt.Message)) error
+	Unsubscribe(topic string) error
+	// Connected reports whether the connection to the broker is up
+	Connected() bool
 	Close()
 }
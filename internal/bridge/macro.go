@@ -0,0 +1,254 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+)
+
+// Macro run and step states reported on the status topic
+const (
+	MacroRunning = "running"
+	MacroDone    = "done"
+	MacroFailed  = "failed"
+
+	StepOK      = "ok"
+	StepQueued  = "queued"
+	StepSkipped = "skipped"
+	StepFailed  = "failed"
+)
+
+// MacroStatus is published to <prefix>/<snr>/_macro/<name>/status after every step
+type MacroStatus struct {
+	Macro     string `json:"macro"`
+	State     string `json:"state"`
+	Step      int    `json:"step,omitempty"` // 1-based index of the reported step
+	Steps     int    `json:"steps"`
+	StepState string `json:"stepState,omitempty"`
+	Command   string `json:"command,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Ts        string `json:"ts"`
+}
+
+// macroRunner keeps track of running macros, each macro runs at most once at a time
+type macroRunner struct {
+	mu      sync.Mutex
+	defs    map[string]config.Macro // Replaced on reload
+	running map[string]bool
+}
+
+// newMacroRunner returns nil without a macros file, an empty file can still gain macros on reload
+func newMacroRunner(m *config.Macros) *macroRunner {
+	if m == nil {
+		return nil
+	}
+	return &macroRunner{defs: m.Macros, running: make(map[string]bool)}
+}
+
+// replace swaps the definitions after the macros file was reloaded, running macros finish
+// with the steps they started with
+func (r *macroRunner) replace(m *config.Macros) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defs = m.Macros
+}
+
+// acquire marks a macro as running, false if it is unknown or already running
+func (r *macroRunner) acquire(name string) (config.Macro, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.defs[name]
+	if !ok {
+		return m, fmt.Errorf("unknown macro %q", name)
+	}
+	if r.running[name] {
+		return m, fmt.Errorf("macro %q is already running", name)
+	}
+	r.running[name] = true
+	return m, nil
+}
+
+func (r *macroRunner) release(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.running, name)
+}
+
+// handleMacroMessage starts a macro on <prefix>/<snr>/_macro/<name>/run
+func (b *Bridge) handleMacroMessage(msg *mqtt.Message) {
+	topic := msg.Topic
+	prefix := fmt.Sprintf("%s/%s/_macro/", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr)
+	name, ok := strings.CutSuffix(strings.TrimPrefix(topic, prefix), "/run")
+	if !ok || !strings.HasPrefix(topic, prefix) || strings.Contains(name, "/") {
+		return
+	}
+
+	macro, err := b.macros.acquire(name)
+	if err != nil {
+		slog.Warn("Cannot run macro", "macro", name, "error", err)
+		b.publishMacroStatus(name, MacroStatus{State: MacroFailed, Error: err.Error()})
+		return
+	}
+
+	go func() {
+		defer b.macros.release(name)
+		b.runMacro(name, macro)
+	}()
+}
+
+// runMacro executes the steps in order and reports each on the status topic
+func (b *Bridge) runMacro(name string, macro config.Macro) {
+	run := &mqtt.Message{Topic: fmt.Sprintf("%s/%s/_macro/%s/run", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr, name)}
+	total := len(macro.Steps)
+	slog.Info("Running macro", "macro", name, "steps", total)
+	b.publishMacroStatus(name, MacroStatus{State: MacroRunning, Steps: total})
+
+	failed := false
+	for i, step := range macro.Steps {
+		status := MacroStatus{State: MacroRunning, Step: i + 1, Steps: total}
+
+		met := true
+		var condErr error
+		if step.If != nil {
+			met, condErr = b.macroCondition(step.If)
+		}
+
+		switch {
+		// Checked first, the condition applies to delay steps too
+		case condErr != nil:
+			status.StepState = StepFailed
+			status.Error = condErr.Error()
+
+		case !met:
+			status.StepState = StepSkipped
+
+		case step.Delay > 0:
+			select {
+			case <-b.done:
+				return
+			case <-time.After(time.Duration(step.Delay)):
+			}
+			status.StepState = StepOK
+
+		default:
+			ctrl, found := b.lookupControl(step.Control)
+			if !found {
+				status.StepState = StepFailed
+				status.Error = fmt.Sprintf("unknown control %q", step.Control)
+				break
+			}
+			started := time.Now()
+			result := b.execCommand(run, ctrl, step.Payload())
+			b.audit(&mqtt.Message{Topic: run.Topic, Payload: step.Payload()}, ctrl, started, result)
+			status.Command = result.Command
+			status.Error = result.Error
+			switch {
+			case !result.OK:
+				status.StepState = StepFailed
+			case result.Queued:
+				status.StepState = StepQueued
+			default:
+				status.StepState = StepOK
+			}
+		}
+
+		if status.StepState == StepFailed {
+			failed = true
+			slog.Warn("Macro step failed", "macro", name, "step", i+1, "error", status.Error)
+			if !macro.ContinueOnError {
+				status.State = MacroFailed
+				b.publishMacroStatus(name, status)
+				return
+			}
+		}
+		b.publishMacroStatus(name, status)
+	}
+
+	final := MacroStatus{State: MacroDone, Steps: total}
+	if failed {
+		final.State = MacroFailed
+		final.Error = "one or more steps failed"
+	}
+	slog.Info("Macro finished", "macro", name, "state", final.State)
+	b.publishMacroStatus(name, final)
+}
+
+// lookupControl resolves "<room>/<control>" or a uuidAction
+func (b *Bridge) lookupControl(ref string) (*loxone.Control, bool) {
+	if room, control, ok := strings.Cut(ref, "/"); ok {
+		return b.reg().LookupControlByPath(room, control)
+	}
+	return b.reg().LookupControlByAction(ref)
+}
+
+// macroCondition compares the cached value of a state, false if the state has no value yet.
+// An error if the state doesn't exist, so a typo fails the step instead of skipping it.
+func (b *Bridge) macroCondition(c *config.MacroCondition) (bool, error) {
+	var state *State
+	found := false
+	if parts := strings.Split(c.State, "/"); len(parts) == 3 {
+		state, found = b.reg().LookupStateBySegment(parts[0], parts[1], parts[2])
+	} else if u, err := ParseUUID(c.State); err == nil {
+		state, found = b.reg().LookupState(u)
+	}
+	if !found {
+		return false, fmt.Errorf("unknown condition state %q", c.State)
+	}
+	if b.cache == nil {
+		return false, nil
+	}
+	payload, ok := b.cache.Get(state.UUID)
+	if !ok {
+		return false, nil
+	}
+	return compareValues(payload.Value, c.Op, c.Value), nil
+}
+
+// compareValues compares numerically if both sides are numbers, otherwise as text (eq and ne only)
+func compareValues(actual interface{}, op string, expected interface{}) bool {
+	a, errA := toNumber(actual)
+	e, errE := toNumber(expected)
+	if errA == nil && errE == nil {
+		switch op {
+		case "", "eq":
+			return a == e
+		case "ne":
+			return a != e
+		case "gt":
+			return a > e
+		case "ge":
+			return a >= e
+		case "lt":
+			return a < e
+		case "le":
+			return a <= e
+		}
+		return false
+	}
+
+	equal := rawString(actual) == rawString(expected)
+	switch op {
+	case "", "eq":
+		return equal
+	case "ne":
+		return !equal
+	}
+	return false
+}
+
+func (b *Bridge) publishMacroStatus(name string, status MacroStatus) {
+	status.Macro = name
+	status.Ts = time.Now().UTC().Format(time.RFC3339)
+	topic := fmt.Sprintf("%s/%s/_macro/%s/status", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr, name)
+	payload, _ := json.Marshal(status)
+	if err := b.mqtt.Publish(topic, 0, false, payload); err != nil {
+		slog.Error("Failed to publish macro status", "error", err)
+	}
+}
@@ -0,0 +1,111 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridge_RunMacro(t *testing.T) {
+	mockLox := new(MockLoxoneProvider)
+	mockMQTT := new(MockMQTTProvider)
+
+	uuidSpots := "20000000-0000-0000-0000-000000000011"
+	uuidBlinds := "20000000-0000-0000-0000-000000000012"
+	uuidPosition := "30000000-0000-0000-0000-000000000012"
+	structure := &loxone.LoxApp3{
+		Rooms: map[string]*loxone.Room{"r1": {Name: "Living Room", UUID: "r1"}},
+		Controls: map[string]*loxone.Control{
+			"c1": {Name: "Spots", Room: "r1", Type: "Dimmer", UUIDAction: uuidSpots},
+			"c2": {
+				Name: "Blinds", Room: "r1", Type: "Jalousie", UUIDAction: uuidBlinds,
+				States: map[string]interface{}{"position": uuidPosition},
+			},
+		},
+	}
+
+	macros := &config.Macros{Macros: map[string]config.Macro{
+		"movie-mode": {
+			ContinueOnError: true,
+			Steps: []config.MacroStep{
+				{Control: "living-room/spots", Command: json.RawMessage(`"20"`)},
+				{Delay: config.Duration(1)},
+				{Control: uuidBlinds, Command: json.RawMessage(`{"cmd":"FullDown"}`),
+					If: &config.MacroCondition{State: "living-room/blinds/jalousie_position", Op: "lt", Value: 1.0}},
+				{Control: "living-room/spots", Command: json.RawMessage(`"on"`),
+					If: &config.MacroCondition{State: uuidPosition, Op: "gt", Value: 0.5}},
+				{Control: "kitchen/missing", Command: json.RawMessage(`"on"`)},
+				{Delay: config.Duration(1), If: &config.MacroCondition{State: uuidPosition, Op: "gt", Value: 0.5}},
+				{Control: "living-room/spots", Command: json.RawMessage(`"off"`),
+					If: &config.MacroCondition{State: "living-room/blinds/jalousie_postion", Op: "lt", Value: 1.0}},
+			},
+		},
+	}}
+
+	cfg := &config.Config{
+		Loxone: config.LoxoneConfig{Snr: "504F94A00000"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "loxone"},
+		Macros: macros,
+	}
+	b := &Bridge{
+		cfg:      cfg,
+		lox:      mockLox,
+		mqtt:     mockMQTT,
+		registry: NewRegistry(structure, nil),
+		cache:    NewStateCache(),
+		macros:   newMacroRunner(macros),
+		done:     make(chan struct{}),
+	}
+	b.cache.Set(uuid.MustParse(uuidPosition), Payload{Value: 0.25})
+
+	mockLox.On("SendCommand", fmt.Sprintf("jdev/sps/io/%s/20", uuidSpots)).Return(nil).Once()
+	mockLox.On("SendCommand", fmt.Sprintf("jdev/sps/io/%s/FullDown", uuidBlinds)).Return(nil).Once()
+
+	var statuses []MacroStatus
+	mockMQTT.On("Publish", "loxone/504F94A00000/_macro/movie-mode/status", byte(0), false, mock.Anything).Run(func(args mock.Arguments) {
+		var s MacroStatus
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &s))
+		statuses = append(statuses, s)
+	}).Return(nil)
+
+	m, err := b.macros.acquire("movie-mode")
+	require.NoError(t, err)
+	_, err = b.macros.acquire("movie-mode")
+	assert.Error(t, err, "a running macro cannot be started twice")
+	b.runMacro("movie-mode", m)
+	b.macros.release("movie-mode")
+
+	require.Len(t, statuses, 9)
+	assert.Equal(t, MacroRunning, statuses[0].State)
+	assert.Equal(t, 7, statuses[0].Steps)
+	assert.Equal(t, StepOK, statuses[1].StepState)
+	assert.Equal(t, "20", statuses[1].Command)
+	assert.Equal(t, StepOK, statuses[2].StepState) // Delay
+	assert.Equal(t, StepOK, statuses[3].StepState)
+	assert.Equal(t, "FullDown", statuses[3].Command)
+	assert.Equal(t, StepSkipped, statuses[4].StepState)
+	assert.Equal(t, StepFailed, statuses[5].StepState)
+	assert.Contains(t, statuses[5].Error, "unknown control")
+	assert.Equal(t, StepSkipped, statuses[6].StepState, "conditional delay")
+	assert.Equal(t, StepFailed, statuses[7].StepState, "a condition on an unknown state fails")
+	assert.Contains(t, statuses[7].Error, "unknown condition state")
+	assert.Equal(t, MacroFailed, statuses[8].State)
+
+	mockLox.AssertExpectations(t)
+}
+
+func TestCompareValues(t *testing.T) {
+	assert.True(t, compareValues(1.0, "eq", "1"))
+	assert.True(t, compareValues(0.25, "lt", 1.0))
+	assert.False(t, compareValues(0.25, "ge", 1.0))
+	assert.True(t, compareValues("Movie", "", "Movie"))
+	assert.True(t, compareValues("Movie", "ne", "Music"))
+	assert.False(t, compareValues("Movie", "gt", "Music"))
+}
@@ -0,0 +1,362 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+)
+
+// Management actions, requested on <prefix>/_bridge/request/<action>
+const (
+	ManageInfo      = "info"
+	ManageLogLevel  = "loglevel"
+	ManageReload    = "reload"
+	ManageRepublish = "republish"
+	ManageClear     = "clear"
+	ManageReconnect = "reconnect"
+)
+
+var errManagementBusy = errors.New("another management request is running")
+
+// readOnlyActions are the management actions allowed on a read-only bridge, the others change
+// its configuration, connections or retained topics
+var readOnlyActions = map[string]bool{ManageInfo: true, ManageRepublish: true}
+
+// managementControl stands for the bridge in the command policy: rules match management
+// requests by the type or control name "_bridge" and the action as command
+var managementControl = &loxone.Control{Name: "_bridge", Type: "_bridge"}
+
+// Options are runtime settings of the bridge that don't come from the environment
+type Options struct {
+	Version  string         // Reported by the info request
+	LogLevel *slog.LevelVar // Changed by the loglevel request, nil if it is fixed
+}
+
+// ManagementResponse is published to <prefix>/_bridge/response/<action>
+type ManagementResponse struct {
+	OK     bool        `json:"ok"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	Ts     string      `json:"ts"`
+}
+
+// BridgeInfo is the result of the info request
+type BridgeInfo struct {
+	Version         string `json:"version"`
+	GoVersion       string `json:"goVersion"`
+	Started         string `json:"started"`
+	Uptime          int64  `json:"uptime"` // Seconds
+	LoxoneConnected bool   `json:"loxoneConnected"`
+	Controls        int    `json:"controls"`
+	States          int    `json:"states"`
+	LogLevel        string `json:"logLevel,omitempty"`
+}
+
+// managementTopic returns <prefix>/_bridge/<kind>/<action>, kind is request or response
+func (b *Bridge) managementTopic(kind, action string) string {
+	return fmt.Sprintf("%s/_bridge/%s/%s", b.cfg.MQTT.TopicPrefix, kind, action)
+}
+
+// handleManagementMessage runs a management request. Requests publish the whole tree or wait
+// for Loxone, so they run outside the MQTT client's goroutine.
+func (b *Bridge) handleManagementMessage(msg *mqtt.Message) {
+	action, ok := strings.CutPrefix(msg.Topic, b.managementTopic("request", ""))
+	if !ok || action == "" || strings.Contains(action, "/") {
+		return
+	}
+
+	slog.Info("Management request", "action", action)
+	if err := b.authorizeManagement(action); err != nil {
+		b.respondManagement(msg, action, nil, err)
+		return
+	}
+	go func() {
+		result, err := b.manage(action, msg.Payload)
+		b.respondManagement(msg, action, result, err)
+	}()
+}
+
+// authorizeManagement checks a management request against read-only mode and the command policy
+func (b *Bridge) authorizeManagement(action string) error {
+	if b.cfg.Bridge.ReadOnly && !readOnlyActions[action] {
+		return fmt.Errorf("bridge is read-only")
+	}
+	return checkPolicy(b.commandPolicy(), commandTarget{Control: managementControl, Command: action})
+}
+
+// manage executes an action and returns its result
+func (b *Bridge) manage(action string, payload []byte) (interface{}, error) {
+	switch action {
+	case ManageInfo:
+		return b.info(), nil
+	case ManageLogLevel:
+		return b.changeLogLevel(payload)
+	case ManageReconnect:
+		slog.Info("Reconnecting to Loxone on request")
+		b.lox.Disconnect()
+		return nil, nil
+	case ManageReload:
+		return b.exclusive(b.reload)
+	case ManageRepublish:
+		return b.exclusive(func() (interface{}, error) {
+			return map[string]int{"states": b.republishAll()}, nil
+		})
+	case ManageClear:
+		return b.exclusive(b.clear)
+	}
+	return nil, fmt.Errorf("unknown action %q", action)
+}
+
+// exclusive runs one of the actions publishing the whole tree, at most one at a time
+func (b *Bridge) exclusive(fn func() (interface{}, error)) (interface{}, error) {
+	if !b.managing.CompareAndSwap(false, true) {
+		return nil, errManagementBusy
+	}
+	defer b.managing.Store(false)
+	return fn()
+}
+
+// respondManagement publishes the response to a management request, see respondOn
+func (b *Bridge) respondManagement(req *mqtt.Message, action string, result interface{}, err error) {
+	resp := ManagementResponse{OK: err == nil, Result: result, Ts: time.Now().UTC().Format(time.RFC3339)}
+	if err != nil {
+		slog.Warn("Management request failed", "action", action, "error", err)
+		resp.Error = err.Error()
+	}
+	payload, _ := json.Marshal(resp)
+	b.respondOn(req, b.managementTopic("response", action), payload)
+}
+
+func (b *Bridge) info() BridgeInfo {
+	info := BridgeInfo{
+		Version:         b.version,
+		GoVersion:       runtime.Version(),
+		LoxoneConnected: b.loxoneConnected.Load(),
+	}
+	if !b.started.IsZero() {
+		info.Started = b.started.UTC().Format(time.RFC3339)
+		info.Uptime = int64(time.Since(b.started).Seconds())
+	}
+	if r := b.reg(); r != nil {
+		info.Controls = len(r.controlLookup)
+		info.States = len(r.states)
+	}
+	if b.logLevel != nil {
+		info.LogLevel = b.logLevel.Level().String()
+	}
+	return info
+}
+
+// changeLogLevel sets the level named in the payload ("debug" or {"level": "debug"}),
+// an empty payload only reports the current level
+func (b *Bridge) changeLogLevel(payload []byte) (interface{}, error) {
+	if b.logLevel == nil {
+		return nil, fmt.Errorf("log level is not adjustable")
+	}
+
+	name := strings.Trim(string(bytes.TrimSpace(payload)), `"`)
+	var req struct {
+		Level string `json:"level"`
+	}
+	if json.Unmarshal(payload, &req) == nil && req.Level != "" {
+		name = req.Level
+	}
+
+	if name != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q", name)
+		}
+		b.logLevel.Set(level)
+		slog.Info("Log level changed", "level", level)
+	}
+	return map[string]string{"level": b.logLevel.Level().String()}, nil
+}
+
+// reload fetches the structure and the mapping, command policy and macros files again,
+// publishes the infos, discovery and Homie description of the new registry and removes the
+// retained topics of vanished states. Nothing is applied unless every file is valid.
+func (b *Bridge) reload() (interface{}, error) {
+	// The files only live in the bridge, b.cfg is shared with the handlers and stays unchanged
+	mapping := b.cfg.Mapping
+	if b.cfg.Bridge.MappingFile != "" {
+		var err error
+		if mapping, err = config.LoadMapping(b.cfg.Bridge.MappingFile); err != nil {
+			return nil, err
+		}
+	}
+	var policy *config.CommandPolicy
+	if b.cfg.Bridge.CommandPolicyFile != "" {
+		var err error
+		if policy, err = config.LoadCommandPolicy(b.cfg.Bridge.CommandPolicyFile); err != nil {
+			return nil, err
+		}
+	}
+	var macros *config.Macros
+	if b.cfg.Bridge.MacrosFile != "" && b.macros != nil {
+		var err error
+		if macros, err = config.LoadMacros(b.cfg.Bridge.MacrosFile); err != nil {
+			return nil, err
+		}
+	}
+
+	structure, err := b.lox.GetStructure()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get structure: %v", err)
+	}
+
+	if policy != nil {
+		b.setPolicy(policy)
+		slog.Info("Command policy reloaded", "rules", len(policy.Rules))
+	}
+	if macros != nil {
+		b.macros.replace(macros)
+		slog.Info("Macros reloaded", "macros", len(macros.Macros))
+	}
+
+	old := b.retainedTopics(b.reg())
+	if b.homie != nil {
+		maps.Copy(old, b.homie.topics())
+	}
+	b.setRegistry(NewRegistry(structure, mapping))
+	slog.Info("Registry reloaded", "controls", len(structure.Controls))
+
+	// Discovery removes the configs of vanished controls itself
+	b.publishInfos(structure)
+	b.publishDiscovery()
+	current := b.retainedTopics(b.reg())
+	if b.homie != nil {
+		b.publishHomie(structure.MsInfo)
+		maps.Copy(current, b.homie.topics())
+	}
+
+	for topic := range current {
+		delete(old, topic)
+	}
+	removed := b.clearTopics(old)
+
+	result := map[string]int{
+		"controls": len(structure.Controls),
+		"states":   b.republishAll(),
+		"removed":  removed,
+	}
+	if policy != nil {
+		result["policyRules"] = len(policy.Rules)
+	}
+	if macros != nil {
+		result["macros"] = len(macros.Macros)
+	}
+	return result, nil
+}
+
+// clear removes the retained topics of the current structure, including its Home Assistant
+// discovery configs and Homie device
+func (b *Bridge) clear() (interface{}, error) {
+	topics := b.retainedTopics(b.reg())
+	if b.hass != nil {
+		maps.Copy(topics, b.hass.withdraw())
+	}
+	if b.homie != nil {
+		maps.Copy(topics, b.homie.topics())
+	}
+	return map[string]int{"cleared": b.clearTopics(topics)}, nil
+}
+
+// republishAll publishes the cached value of every state and returns their number
+func (b *Bridge) republishAll() int {
+	registry := b.reg()
+	if registry == nil || b.cache == nil {
+		return 0
+	}
+
+	// Wait for the pipeline now and then, a structure larger than its queue would drop states
+	batch := 0
+	if b.publisher != nil {
+		batch = max(b.publisher.Stats().Capacity/2, 1)
+	}
+
+	n := 0
+	for u := range registry.states {
+		if _, ok := b.cache.Get(u); !ok {
+			continue
+		}
+		b.republish(u)
+		n++
+		if batch > 0 && n%batch == 0 {
+			b.publisher.Flush(publishDrainTimeout)
+		}
+	}
+	if b.publisher != nil {
+		b.publisher.Flush(publishDrainTimeout)
+	}
+	slog.Info("Republished states", "count", n)
+	return n
+}
+
+// retainedTopics returns the retained topics a registry's structure publishes below
+// <prefix>/<snr>: info documents, states, their UUID mirrors and aggregated states
+func (b *Bridge) retainedTopics(r *Registry) map[string]bool {
+	if r == nil {
+		return map[string]bool{}
+	}
+	root := fmt.Sprintf("%s/%s", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr)
+	topics := map[string]bool{root + "/_info": true}
+	for _, room := range r.rooms {
+		topics[fmt.Sprintf("%s/%s/_info", root, sanitize(room.Name))] = true
+	}
+	for path := range r.controlLookup {
+		topics[fmt.Sprintf("%s/%s/_info", root, path)] = true
+		if b.cfg.Bridge.AggregateState {
+			topics[fmt.Sprintf("%s/%s/state", root, path)] = true
+		}
+	}
+	for _, s := range r.states {
+		for topic := range b.stateTopics(&s) {
+			topics[topic] = true
+		}
+	}
+	return topics
+}
+
+// stateTopics returns the retained topics a state is published to: its state topic and UUID mirror
+func (b *Bridge) stateTopics(s *State) map[string]bool {
+	root := fmt.Sprintf("%s/%s", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr)
+	topics := map[string]bool{fmt.Sprintf("%s/%s", root, s.Path()): true}
+	if b.cfg.Bridge.UUIDTopics {
+		topics[fmt.Sprintf("%s/uuid/%s", root, LoxoneUUID(s.UUID))] = true
+	}
+	return topics
+}
+
+// clearTopics removes retained topics by publishing empty retained payloads
+func (b *Bridge) clearTopics(topics map[string]bool) int {
+	sorted := make([]string, 0, len(topics))
+	for topic := range topics {
+		sorted = append(sorted, topic)
+	}
+	sort.Strings(sorted)
+
+	n := 0
+	for _, topic := range sorted {
+		if err := b.mqtt.Publish(topic, 1, true, []byte{}); err != nil {
+			slog.Error("Failed to clear retained topic", "topic", topic, "error", err)
+			continue
+		}
+		n++
+	}
+	if n > 0 {
+		slog.Info("Cleared retained topics", "count", n)
+	}
+	return n
+}
@@ -0,0 +1,216 @@
+package bridge
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newManagementBridge builds a test bridge of the test light with a version and adjustable log level
+func newManagementBridge(t *testing.T) (*Bridge, *MockMQTTProvider, *MockLoxoneProvider) {
+	b, mockMQTT, mockLox := newTestBridge(t, testStructure(testLight("Light")), config.BridgeConfig{})
+	b.version = "1.2.3"
+	b.logLevel = new(slog.LevelVar)
+	return b, mockMQTT, mockLox
+}
+
+func TestBridge_ManagementInfoAndLogLevel(t *testing.T) {
+	b, mockMQTT, _ := newManagementBridge(t)
+
+	result, err := b.manage(ManageInfo, nil)
+	require.NoError(t, err)
+	info := result.(BridgeInfo)
+	assert.Equal(t, "1.2.3", info.Version)
+	assert.Equal(t, 1, info.Controls)
+	assert.Equal(t, 1, info.States)
+	assert.Equal(t, "INFO", info.LogLevel)
+
+	result, err = b.manage(ManageLogLevel, []byte("debug"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"level": "DEBUG"}, result)
+	result, err = b.manage(ManageLogLevel, []byte(`{"level": "warn"}`))
+	require.NoError(t, err)
+	assert.Equal(t, slog.LevelWarn, b.logLevel.Level())
+	_, err = b.manage(ManageLogLevel, []byte("verbose"))
+	assert.EqualError(t, err, `invalid log level "verbose"`)
+
+	_, err = b.manage("shutdown", nil)
+	assert.EqualError(t, err, `unknown action "shutdown"`)
+
+	// Responses go to the response topic of the action, or the v5 response topic of the request
+	mockMQTT.On("Publish", "loxone/_bridge/response/loglevel", byte(0), false, mock.MatchedBy(func(p []byte) bool {
+		var resp ManagementResponse
+		return json.Unmarshal(p, &resp) == nil && resp.OK && resp.Result.(map[string]interface{})["level"] == "WARN"
+	})).Return(nil).Once()
+	b.respondManagement(&mqtt.Message{Topic: "loxone/_bridge/request/loglevel"}, ManageLogLevel, result, nil)
+
+	mockMQTT.On("PublishMessage", mock.MatchedBy(func(m *mqtt.Message) bool {
+		return m.Topic == "app/responses" && string(m.Properties.CorrelationData) == "req-1"
+	})).Return(nil).Once()
+	b.respondManagement(&mqtt.Message{
+		Topic:      "loxone/_bridge/request/info",
+		Properties: &mqtt.Properties{ResponseTopic: "app/responses", CorrelationData: []byte("req-1")},
+	}, ManageInfo, info, nil)
+	mockMQTT.AssertExpectations(t)
+}
+
+func TestBridge_ManagementReload(t *testing.T) {
+	b, mockMQTT, mockLox := newManagementBridge(t)
+	u, err := ParseUUID("10000000-0000-0000-0000000000000001")
+	require.NoError(t, err)
+	b.cache.Set(u, Payload{Value: 1.0})
+
+	// The light was renamed in Loxone Config
+	mockLox.On("GetStructure").Return(testStructure(testLight("Ceiling")), nil).Once()
+	mockMQTT.On("Publish", mock.Anything, mock.Anything, true, mock.Anything).Return(nil)
+
+	result, err := b.manage(ManageReload, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"controls": 1, "states": 1, "removed": 2}, result)
+
+	root := "loxone/504F94A00000/living-room/"
+	mockMQTT.AssertCalled(t, "Publish", root+"light/_info", byte(1), true, []byte{})
+	mockMQTT.AssertCalled(t, "Publish", root+"light/switch_active", byte(1), true, []byte{})
+	mockMQTT.AssertNotCalled(t, "Publish", root+"_info", byte(1), true, []byte{})
+	mockMQTT.AssertCalled(t, "Publish", root+"ceiling/_info", byte(1), true, mock.Anything)
+	mockMQTT.AssertCalled(t, "Publish", root+"ceiling/switch_active", byte(0), true, mock.Anything)
+
+	_, ok := b.reg().LookupControlByPath("living-room", "ceiling")
+	assert.True(t, ok)
+	mockLox.AssertExpectations(t)
+}
+
+func TestBridge_ManagementReloadMapping(t *testing.T) {
+	b, mockMQTT, mockLox := newManagementBridge(t)
+	file := filepath.Join(t.TempDir(), "mapping.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"controls": {"20000000-0000-0000-0000000000000001": {"control": "ceiling"}}}`), 0o600))
+	b.cfg.Bridge.MappingFile = file
+
+	mockLox.On("GetStructure").Return(testStructure(testLight("Light")), nil).Once()
+	mockMQTT.On("Publish", mock.Anything, mock.Anything, true, mock.Anything).Return(nil)
+	_, err := b.manage(ManageReload, nil)
+	require.NoError(t, err)
+
+	_, ok := b.reg().LookupControlByPath("living-room", "ceiling")
+	assert.True(t, ok, "the new registry uses the reloaded mapping")
+	assert.Nil(t, b.cfg.Mapping, "the shared config is not modified")
+}
+
+func TestBridge_ManagementReloadPolicyAndMacros(t *testing.T) {
+	b, mockMQTT, mockLox := newManagementBridge(t)
+	dir := t.TempDir()
+	b.cfg.Bridge.CommandPolicyFile = filepath.Join(dir, "policy.json")
+	b.cfg.Bridge.MacrosFile = filepath.Join(dir, "macros.json")
+	b.macros = newMacroRunner(&config.Macros{})
+	require.NoError(t, os.WriteFile(b.cfg.Bridge.CommandPolicyFile,
+		[]byte(`{"default": "deny", "rules": [{"effect": "allow", "types": ["_bridge"]}]}`), 0o600))
+	require.NoError(t, os.WriteFile(b.cfg.Bridge.MacrosFile,
+		[]byte(`{"macros": {"all-off": {"steps": [{"control": "living-room/light", "command": "Off"}]}}}`), 0o600))
+
+	mockLox.On("GetStructure").Return(testStructure(testLight("Light")), nil).Once()
+	mockMQTT.On("Publish", mock.Anything, mock.Anything, true, mock.Anything).Return(nil)
+	result, err := b.manage(ManageReload, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.(map[string]int)["policyRules"])
+	assert.Equal(t, 1, result.(map[string]int)["macros"])
+
+	assert.NoError(t, b.authorizeManagement(ManageReload))
+	assert.Error(t, authorize(false, b.commandPolicy(), commandTarget{Control: &loxone.Control{Type: "Switch"}, Command: "On"}),
+		"the reloaded policy applies to commands")
+	_, err = b.macros.acquire("all-off")
+	assert.NoError(t, err, "the reloaded macro can be run")
+	b.macros.release("all-off")
+	assert.Nil(t, b.cfg.Policy, "the shared config is not modified")
+
+	// An invalid file fails the reload without applying anything
+	require.NoError(t, os.WriteFile(b.cfg.Bridge.MacrosFile, []byte(`{"macros": `), 0o600))
+	require.NoError(t, os.WriteFile(b.cfg.Bridge.CommandPolicyFile, []byte(`{"default": "allow"}`), 0o600))
+	_, err = b.manage(ManageReload, nil)
+	assert.Error(t, err)
+	assert.Equal(t, config.PolicyDeny, b.commandPolicy().Default)
+	mockLox.AssertExpectations(t)
+}
+
+func TestBridge_ManagementClearAndReconnect(t *testing.T) {
+	b, mockMQTT, mockLox := newManagementBridge(t)
+	b.cfg.Bridge.UUIDTopics = true
+
+	mockMQTT.On("Publish", mock.Anything, byte(1), true, []byte{}).Return(nil)
+	result, err := b.manage(ManageClear, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"cleared": 5}, result, "ms, room and control info, state and UUID mirror")
+	mockMQTT.AssertCalled(t, "Publish", "loxone/504F94A00000/uuid/10000000-0000-0000-0000000000000001", byte(1), true, []byte{})
+
+	// One request publishing the whole tree at a time
+	b.managing.Store(true)
+	_, err = b.manage(ManageRepublish, nil)
+	assert.ErrorIs(t, err, errManagementBusy)
+	b.managing.Store(false)
+
+	mockLox.On("Disconnect").Return().Once()
+	_, err = b.manage(ManageReconnect, nil)
+	require.NoError(t, err)
+	mockLox.AssertExpectations(t)
+}
+
+func TestBridge_ManagementClearDiscoveryAndHomie(t *testing.T) {
+	b, mockMQTT, _ := newManagementBridge(t)
+	b.hass = newHassDiscovery("homeassistant")
+	b.homie = newHomieDevice("homie", 5, b.cfg.Loxone.Snr)
+	b.homie.build(b.reg())
+	mockMQTT.On("Publish", mock.Anything, byte(1), true, mock.Anything).Return(nil)
+	b.publishDiscovery()
+
+	result, err := b.manage(ManageClear, nil)
+	require.NoError(t, err)
+	// ms, room and control info, state, discovery config, Homie description, value and $state
+	assert.Equal(t, map[string]int{"cleared": 8}, result)
+	for _, topic := range []string{
+		"homeassistant/switch/lox_504F94A00000/20000000-0000-0000-0000000000000001/config",
+		"homie/5/lox-504f94a00000/$description",
+		"homie/5/lox-504f94a00000/living-room-light/active",
+		"homie/5/lox-504f94a00000/$state",
+	} {
+		mockMQTT.AssertCalled(t, "Publish", topic, byte(1), true, []byte{})
+	}
+}
+
+func TestBridge_ManagementAuthorization(t *testing.T) {
+	b, mockMQTT, _ := newManagementBridge(t)
+	denied := func(action string) {
+		mockMQTT.On("Publish", "loxone/_bridge/response/"+action, byte(0), false, mock.MatchedBy(func(p []byte) bool {
+			var resp ManagementResponse
+			return json.Unmarshal(p, &resp) == nil && !resp.OK
+		})).Return(nil).Once()
+		b.handleManagementMessage(&mqtt.Message{Topic: "loxone/_bridge/request/" + action})
+	}
+
+	// A read-only bridge only answers requests that change nothing
+	b.cfg.Bridge.ReadOnly = true
+	assert.NoError(t, b.authorizeManagement(ManageInfo))
+	assert.NoError(t, b.authorizeManagement(ManageRepublish))
+	for _, action := range []string{ManageClear, ManageReload, ManageReconnect, ManageLogLevel} {
+		assert.EqualError(t, b.authorizeManagement(action), "bridge is read-only", action)
+	}
+	denied(ManageClear)
+	b.cfg.Bridge.ReadOnly = false
+
+	// The command policy matches the bridge as type _bridge
+	b.setPolicy(&config.CommandPolicy{Default: config.PolicyDeny, Rules: []config.PolicyRule{
+		{Effect: config.PolicyAllow, Types: []string{"_bridge"}, Commands: []string{ManageInfo, ManageRepublish}},
+	}})
+	assert.NoError(t, b.authorizeManagement(ManageInfo))
+	assert.EqualError(t, b.authorizeManagement(ManageReload), "denied by command policy default")
+	denied(ManageReload)
+
+	mockMQTT.AssertExpectations(t)
+}
@@ -2,6 +2,7 @@ package bridge
 
 import (
 	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -38,6 +39,15 @@ func (m *MockLoxoneProvider) GetEvents() <-chan loxone.Event {
 	return args.Get(0).(<-chan loxone.Event)
 }
 
+func (m *MockLoxoneProvider) ConnectionState() <-chan bool {
+	args := m.Called()
+	return args.Get(0).(<-chan bool)
+}
+
+func (m *MockLoxoneProvider) Disconnect() {
+	m.Called()
+}
+
 func (m *MockLoxoneProvider) Close() {
 	m.Called()
 }
@@ -57,11 +67,26 @@ func (m *MockMQTTProvider) Publish(topic string, qos byte, retained bool, payloa
 	return args.Error(0)
 }
 
-func (m *MockMQTTProvider) Subscribe(topic string, qos byte, callback func(topic string, payload []byte)) error {
+func (m *MockMQTTProvider) PublishMessage(msg *mqtt.Message) error {
+	args := m.Called(msg)
+	return args.Error(0)
+}
+
+func (m *MockMQTTProvider) Subscribe(topic string, qos byte, callback func(msg *mqtt.Message)) error {
 	args := m.Called(topic, qos, callback)
 	return args.Error(0)
 }
 
+func (m *MockMQTTProvider) Unsubscribe(topic string) error {
+	args := m.Called(topic)
+	return args.Error(0)
+}
+
+func (m *MockMQTTProvider) Connected() bool {
+	args := m.Called()
+	return args.Bool(0)
+}
+
 func (m *MockMQTTProvider) Close() {
 	m.Called()
 }
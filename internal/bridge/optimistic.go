@@ -0,0 +1,219 @@
+package bridge
+
+import (
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/google/uuid"
+)
+
+// predictor derives the state value a command is expected to produce from its arguments
+type predictor struct {
+	State string
+	Scale float64  // The first argument divided by Scale is the expected value
+	Fixed *float64 // Expected value of commands without arguments
+}
+
+func predictArg(state string, scale float64) predictor {
+	return predictor{State: state, Scale: scale}
+}
+
+func predictFixed(state string, v float64) predictor {
+	return predictor{State: state, Fixed: &v}
+}
+
+// commandPredictors is keyed by control type and lowercase command name, "" for bare value commands.
+// They are the inverse of stateSetters: Jalousie manualPosition/50 is expected to set position 0.5.
+var commandPredictors = map[string]map[string]predictor{
+	"AudioZoneV2": {"volume": predictArg("volume", 1)},
+	"Dimmer":      {"": predictArg("position", 1)},
+	"IRoomControllerV2": {
+		"setcomforttemperature": predictArg("comfortTemperature", 1),
+		"setoperatingmode":      predictArg("operatingMode", 1),
+	},
+	"Jalousie": {
+		"manualposition": predictArg("position", 100),
+		"manuallamelle":  predictArg("shadePosition", 100),
+		"fullup":         predictFixed("position", 0),
+		"fulldown":       predictFixed("position", 1),
+	},
+	"Sauna": {
+		"on":   predictFixed("active", 1),
+		"off":  predictFixed("active", 0),
+		"temp": predictArg("tempTarget", 1),
+	},
+	"Switch": {
+		"on":  predictFixed("active", 1),
+		"off": predictFixed("active", 0),
+	},
+	"ValueSelector": {"": predictArg("value", 1)},
+	"Window":        {"movetoposition": predictArg("position", 100)},
+}
+
+// predict returns the state name and value a command in Loxone notation is expected to produce
+func predict(controlType, cmd string) (string, float64, bool) {
+	preds, ok := commandPredictors[controlType]
+	if !ok {
+		return "", 0, false
+	}
+
+	segments := strings.Split(cmd, "/")
+	for i, s := range segments {
+		if v, err := url.PathUnescape(s); err == nil {
+			segments[i] = v
+		}
+	}
+
+	// Bare value commands, e.g. Dimmer "50"
+	if _, err := strconv.ParseFloat(segments[0], 64); err == nil {
+		segments = append([]string{""}, segments...)
+	}
+
+	p, ok := preds[strings.ToLower(segments[0])]
+	if !ok {
+		return "", 0, false
+	}
+	if p.Fixed != nil {
+		return p.State, *p.Fixed, true
+	}
+	if len(segments) < 2 {
+		return "", 0, false
+	}
+	v, err := strconv.ParseFloat(segments[1], 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return p.State, v / p.Scale, true
+}
+
+// optimisticTracker remembers optimistically published states until Loxone confirms them
+// or the timeout passes
+type optimisticTracker struct {
+	classes map[string]bool // Enabled class keys, see State.Classes
+	timeout time.Duration
+	expired func(u uuid.UUID)
+
+	mu      sync.Mutex
+	pending map[uuid.UUID]*time.Timer
+	stopped bool
+}
+
+// newOptimisticTracker returns nil if optimistic publishing is not enabled for any class
+func newOptimisticTracker(cfg config.BridgeConfig, expired func(u uuid.UUID)) *optimisticTracker {
+	if len(cfg.Optimistic) == 0 {
+		return nil
+	}
+	t := &optimisticTracker{
+		classes: make(map[string]bool),
+		timeout: cfg.OptimisticTimeout,
+		expired: expired,
+		pending: make(map[uuid.UUID]*time.Timer),
+	}
+	for _, class := range cfg.Optimistic {
+		t.classes[sanitize(class)] = true
+	}
+	return t
+}
+
+// Enabled reports whether the state is published optimistically
+func (t *optimisticTracker) Enabled(s *State) bool {
+	if t == nil {
+		return false
+	}
+	for _, class := range s.Classes() {
+		if t.classes[class] {
+			return true
+		}
+	}
+	return false
+}
+
+// Track starts (or restarts) the confirmation timeout of a state
+func (t *optimisticTracker) Track(u uuid.UUID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return
+	}
+	if timer, ok := t.pending[u]; ok {
+		timer.Stop()
+	}
+	t.pending[u] = time.AfterFunc(t.timeout, func() {
+		t.mu.Lock()
+		_, ok := t.pending[u]
+		delete(t.pending, u)
+		t.mu.Unlock()
+		if ok {
+			t.expired(u)
+		}
+	})
+}
+
+// Confirm ends tracking of a state, true if an optimistic value was pending
+func (t *optimisticTracker) Confirm(u uuid.UUID) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timer, ok := t.pending[u]
+	if ok {
+		timer.Stop()
+		delete(t.pending, u)
+	}
+	return ok
+}
+
+func (t *optimisticTracker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	for u, timer := range t.pending {
+		timer.Stop()
+		delete(t.pending, u)
+	}
+}
+
+// publishOptimistic publishes the state value a sent command is expected to produce, flagged
+// as optimistic. The cache is not touched, it only holds values confirmed by Loxone.
+func (b *Bridge) publishOptimistic(ctrl *loxone.Control, cmd string) {
+	if b.optimistic == nil {
+		return
+	}
+	name, value, ok := predict(ctrl.Type, cmd)
+	if !ok {
+		return
+	}
+	for _, s := range b.reg().StatesOf(ctrl) {
+		if s.Name != name || s.Index >= 0 || !b.optimistic.Enabled(&s) {
+			continue
+		}
+		state := s
+		payload := newPayload(&state, value, b.zone)
+		payload.Optimistic = true
+		slog.Debug("Publishing optimistic state", "control", ctrl.Name, "state", name, "value", value)
+		b.optimistic.Track(state.UUID)
+		b.publishState(&state, payload)
+	}
+}
+
+// rollbackOptimistic restores the confirmed value of a state that Loxone did not confirm in time
+func (b *Bridge) rollbackOptimistic(u uuid.UUID) {
+	state, found := b.reg().LookupState(u)
+	if !found {
+		return
+	}
+	slog.Warn("Optimistic state not confirmed, rolling back", "control", state.Control.Name, "state", state.Name)
+	if _, ok := b.cache.Get(u); !ok {
+		// Loxone never reported the state, there is no confirmed value to restore
+		b.clearTopics(b.stateTopics(state))
+		return
+	}
+	b.republish(u)
+}
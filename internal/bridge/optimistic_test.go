@@ -0,0 +1,140 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPredict(t *testing.T) {
+	tests := []struct {
+		ctrlType, cmd string
+		state         string
+		value         float64
+		ok            bool
+	}{
+		{"Switch", "On", "active", 1, true},
+		{"Switch", "off", "active", 0, true},
+		{"Switch", "Pulse", "", 0, false},
+		{"Dimmer", "50", "position", 50, true},
+		{"Jalousie", "manualPosition/25", "position", 0.25, true},
+		{"Jalousie", "FullDown", "position", 1, true},
+		{"Jalousie", "manualPosition", "", 0, false},
+		{"Window", "moveToPosition/80", "position", 0.8, true},
+		{"InfoOnlyAnalog", "1", "", 0, false},
+	}
+	for _, tt := range tests {
+		state, value, ok := predict(tt.ctrlType, tt.cmd)
+		assert.Equal(t, tt.ok, ok, "%s %s", tt.ctrlType, tt.cmd)
+		assert.Equal(t, tt.state, state, "%s %s", tt.ctrlType, tt.cmd)
+		assert.InDelta(t, tt.value, value, 1e-9, "%s %s", tt.ctrlType, tt.cmd)
+	}
+}
+
+func TestBridge_Optimistic(t *testing.T) {
+	mockLox := new(MockLoxoneProvider)
+	mockMQTT := new(MockMQTTProvider)
+	cfg := &config.Config{
+		Loxone: config.LoxoneConfig{Snr: "504F94A00000"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "loxone"},
+		Bridge: config.BridgeConfig{Optimistic: []string{"switch"}, OptimisticTimeout: 50 * time.Millisecond},
+	}
+
+	uuidAction := "20000000-0000-0000-0000000000000006"
+	uuidActive := "30000000-0000-0000-0000000000000006"
+	structure := &loxone.LoxApp3{
+		Rooms: map[string]*loxone.Room{"r1": {Name: "Living Room", UUID: "r1"}},
+		Controls: map[string]*loxone.Control{
+			"c1": {
+				Name: "Light", Room: "r1", Type: "Switch", UUIDAction: uuidAction,
+				States: map[string]interface{}{"active": uuidActive},
+			},
+		},
+	}
+	b := &Bridge{cfg: cfg, lox: mockLox, mqtt: mockMQTT, registry: NewRegistry(structure, nil), cache: NewStateCache()}
+	b.optimistic = newOptimisticTracker(cfg.Bridge, b.rollbackOptimistic)
+	defer b.optimistic.Stop()
+
+	stateTopic := "loxone/504F94A00000/living-room/light/switch_active"
+	cmdTopic := "loxone/504F94A00000/living-room/light/command"
+	payloadWith := func(s string) interface{} {
+		return mock.MatchedBy(func(p interface{}) bool { return strings.Contains(string(p.([]byte)), s) })
+	}
+	mockLox.On("SendCommand", fmt.Sprintf("jdev/sps/io/%s/On", uuidAction)).Return(nil)
+	mockMQTT.On("Publish", cmdTopic+"/result", byte(0), false, mock.Anything).Return(nil)
+
+	// Confirmed value before the command
+	mockMQTT.On("Publish", stateTopic, byte(0), true, payloadWith(`"value":0,`)).Return(nil).Once()
+	b.handleEvent(loxone.Event{UUID: uuidActive, Value: 0, Type: "Value"})
+
+	// The command publishes the expected value right away, Loxone confirms it
+	mockMQTT.On("Publish", stateTopic, byte(0), true, payloadWith(`"optimistic":true`)).Return(nil).Once()
+	b.handleMQTTMessage(&mqtt.Message{Topic: cmdTopic, Payload: []byte("On")})
+	mockMQTT.On("Publish", stateTopic, byte(0), true, payloadWith(`"value":1,`)).Return(nil).Once()
+	b.handleEvent(loxone.Event{UUID: uuidActive, Value: 1, Type: "Value"})
+	mockMQTT.AssertExpectations(t)
+
+	// Without confirmation the cached value is restored after the timeout
+	mockMQTT.On("Publish", stateTopic, byte(0), true, payloadWith(`"optimistic":true`)).Return(nil).Once()
+	b.handleMQTTMessage(&mqtt.Message{Topic: cmdTopic, Payload: []byte("On")})
+	rolledBack := make(chan struct{})
+	mockMQTT.On("Publish", stateTopic, byte(0), true, payloadWith(`"value":1,`)).Return(nil).Once().
+		Run(func(mock.Arguments) { close(rolledBack) })
+
+	select {
+	case <-rolledBack:
+	case <-time.After(time.Second):
+		t.Fatal("optimistic value was not rolled back")
+	}
+	mockMQTT.AssertExpectations(t)
+}
+
+func TestBridge_OptimisticRollbackWithoutValue(t *testing.T) {
+	mockLox := new(MockLoxoneProvider)
+	mockMQTT := new(MockMQTTProvider)
+	cfg := &config.Config{
+		Loxone: config.LoxoneConfig{Snr: "504F94A00000"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "loxone"},
+		Bridge: config.BridgeConfig{Optimistic: []string{"switch"}, OptimisticTimeout: 50 * time.Millisecond},
+	}
+
+	uuidAction := "20000000-0000-0000-0000000000000006"
+	structure := &loxone.LoxApp3{
+		Rooms: map[string]*loxone.Room{"r1": {Name: "Living Room", UUID: "r1"}},
+		Controls: map[string]*loxone.Control{
+			"c1": {
+				Name: "Light", Room: "r1", Type: "Switch", UUIDAction: uuidAction,
+				States: map[string]interface{}{"active": "30000000-0000-0000-0000000000000006"},
+			},
+		},
+	}
+	b := &Bridge{cfg: cfg, lox: mockLox, mqtt: mockMQTT, registry: NewRegistry(structure, nil), cache: NewStateCache()}
+	b.optimistic = newOptimisticTracker(cfg.Bridge, b.rollbackOptimistic)
+	defer b.optimistic.Stop()
+
+	stateTopic := "loxone/504F94A00000/living-room/light/switch_active"
+	cmdTopic := "loxone/504F94A00000/living-room/light/command"
+	mockLox.On("SendCommand", fmt.Sprintf("jdev/sps/io/%s/On", uuidAction)).Return(nil)
+	mockMQTT.On("Publish", cmdTopic+"/result", byte(0), false, mock.Anything).Return(nil)
+
+	// Loxone never reported the state: the rollback removes the optimistic value
+	mockMQTT.On("Publish", stateTopic, byte(0), true, mock.Anything).Return(nil).Once()
+	b.handleMQTTMessage(&mqtt.Message{Topic: cmdTopic, Payload: []byte("On")})
+	cleared := make(chan struct{})
+	mockMQTT.On("Publish", stateTopic, byte(1), true, []byte{}).Return(nil).Once().
+		Run(func(mock.Arguments) { close(cleared) })
+
+	select {
+	case <-cleared:
+	case <-time.After(time.Second):
+		t.Fatal("optimistic value was not cleared")
+	}
+	mockMQTT.AssertExpectations(t)
+}
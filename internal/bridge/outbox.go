@@ -0,0 +1,293 @@
+package bridge
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+)
+
+var errOutboxFull = errors.New("outbound buffer is full")
+
+// outboxEntry is a buffered message, also the file format of the disk-backed outbox
+type outboxEntry struct {
+	Seq        uint64           `json:"seq"` // Keeps the flush in buffering order
+	Topic      string           `json:"topic"`
+	Payload    []byte           `json:"payload"`
+	QoS        byte             `json:"qos"`
+	Retained   bool             `json:"retained"`
+	Properties *mqtt.Properties `json:"properties,omitempty"`
+}
+
+func (e *outboxEntry) message() *mqtt.Message {
+	return &mqtt.Message{Topic: e.Topic, Payload: e.Payload, QoS: e.QoS, Retained: e.Retained, Properties: e.Properties}
+}
+
+// OutboxStats is a snapshot of the outbound buffer, see Metrics
+type OutboxStats struct {
+	Topics   int    `json:"topics"`
+	Capacity int    `json:"capacity"`
+	Flushed  uint64 `json:"flushed"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+// outbox keeps the latest message per topic while the broker is unreachable. With a directory
+// every entry is also written to a file, so the buffer survives a restart of the bridge.
+type outbox struct {
+	size int
+	dir  string // Empty keeps the entries in memory only
+
+	mu       sync.Mutex
+	entries  map[string]*outboxEntry // Key: topic
+	seq      uint64
+	draining bool // Set while Drain runs, new messages queue up behind the buffered ones
+	flushed  uint64
+	dropped  uint64
+}
+
+// newOutbox creates the buffer, loading the entries left in dir by an earlier run
+func newOutbox(size int, dir string) (*outbox, error) {
+	o := &outbox{size: size, dir: dir, entries: make(map[string]*outboxEntry)}
+	if dir == "" {
+		return o, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create buffer directory: %w", err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read buffered message: %w", err)
+		}
+		var e outboxEntry
+		if err := json.Unmarshal(data, &e); err != nil || e.Topic == "" {
+			slog.Warn("Discarding unreadable buffered message", "file", file, "error", err)
+			os.Remove(file)
+			continue
+		}
+		o.entries[e.Topic] = &e
+		o.seq = max(o.seq, e.Seq)
+	}
+	if len(o.entries) > 0 {
+		slog.Info("Loaded buffered messages", "count", len(o.entries), "dir", dir)
+	}
+	return o, nil
+}
+
+// Put buffers a message, replacing the buffered message of its topic. A new topic is
+// rejected with errOutboxFull if the buffer is full.
+func (o *outbox) Put(msg *mqtt.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.put(msg)
+}
+
+// Hold buffers a message if older messages are buffered or being flushed, so it can't overtake
+// them. False if the buffer is empty and the message can be sent right away.
+func (o *outbox) Hold(msg *mqtt.Message) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.entries) == 0 && !o.draining {
+		return false, nil
+	}
+	return true, o.put(msg)
+}
+
+func (o *outbox) put(msg *mqtt.Message) error {
+	if _, ok := o.entries[msg.Topic]; !ok && len(o.entries) >= o.size {
+		o.dropped++
+		return errOutboxFull
+	}
+	o.seq++
+	e := &outboxEntry{Seq: o.seq, Topic: msg.Topic, Payload: msg.Payload, QoS: msg.QoS, Retained: msg.Retained,
+		Properties: msg.Properties}
+	o.entries[msg.Topic] = e
+	o.write(e)
+	return nil
+}
+
+// Drain sends the buffered messages in buffering order until the buffer is empty or the
+// connection is down again, which keeps the failed and remaining messages. A message failing
+// for any other reason is dropped, so it can't hold back the ones behind it. Returns the
+// number of sent messages.
+func (o *outbox) Drain(send func(*mqtt.Message) error) (int, error) {
+	o.mu.Lock()
+	if o.draining {
+		o.mu.Unlock()
+		return 0, nil
+	}
+	o.draining = true
+	o.mu.Unlock()
+
+	n := 0
+	for {
+		batch := o.snapshot()
+		if len(batch) == 0 {
+			return n, nil
+		}
+
+		for _, e := range batch {
+			if !o.take(e) {
+				continue // Replaced by a newer message, sent with a later batch
+			}
+			if err := send(e.message()); err != nil {
+				if errors.Is(err, mqtt.ErrNotConnected) {
+					o.restore(e)
+					return n, err
+				}
+				slog.Warn("Dropping undeliverable buffered message", "topic", e.Topic, "error", err)
+				o.discard(e)
+				continue
+			}
+			o.sent(e)
+			n++
+		}
+	}
+}
+
+// snapshot returns the buffered entries sorted by age, ending the drain if there are none
+func (o *outbox) snapshot() []*outboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	batch := make([]*outboxEntry, 0, len(o.entries))
+	for _, e := range o.entries {
+		batch = append(batch, e)
+	}
+	if len(batch) == 0 {
+		o.draining = false
+	}
+	sort.Slice(batch, func(i, j int) bool { return batch[i].Seq < batch[j].Seq })
+	return batch
+}
+
+// take removes an entry to send it, false if it was replaced meanwhile
+func (o *outbox) take(e *outboxEntry) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.entries[e.Topic] != e {
+		return false
+	}
+	delete(o.entries, e.Topic)
+	return true
+}
+
+// restore puts back an entry that failed to send, unless a newer message of its topic was buffered
+func (o *outbox) restore(e *outboxEntry) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.entries[e.Topic]; !ok {
+		o.entries[e.Topic] = e
+	}
+	o.draining = false
+}
+
+// sent removes the file of a sent entry, unless a newer message of its topic replaced it
+func (o *outbox) sent(e *outboxEntry) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.flushed++
+	o.remove(e)
+}
+
+// discard removes an entry that can't be delivered, counted as dropped
+func (o *outbox) discard(e *outboxEntry) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.dropped++
+	o.remove(e)
+}
+
+// remove deletes the file of a taken entry, unless a newer message of its topic replaced it
+func (o *outbox) remove(e *outboxEntry) {
+	if _, ok := o.entries[e.Topic]; !ok && o.dir != "" {
+		if err := os.Remove(o.file(e.Topic)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Failed to remove buffered message", "topic", e.Topic, "error", err)
+		}
+	}
+}
+
+// write persists an entry, atomically replacing the file of its topic
+func (o *outbox) write(e *outboxEntry) {
+	if o.dir == "" {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err == nil {
+		tmp := o.file(e.Topic) + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o640); err == nil {
+			err = os.Rename(tmp, o.file(e.Topic))
+		}
+	}
+	if err != nil {
+		slog.Warn("Failed to persist buffered message, keeping it in memory", "topic", e.Topic, "error", err)
+	}
+}
+
+// file is the path of a topic's entry, named by the topic hash since topics contain slashes
+func (o *outbox) file(topic string) string {
+	sum := sha256.Sum256([]byte(topic))
+	return filepath.Join(o.dir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// Len returns the number of buffered topics
+func (o *outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+func (o *outbox) Stats() OutboxStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return OutboxStats{Topics: len(o.entries), Capacity: o.size, Flushed: o.flushed, Dropped: o.dropped}
+}
+
+// deliver sends a state message. While the broker is unreachable, and until the messages
+// buffered meanwhile are flushed, it is kept in the outbox instead. Without an outbox it fails
+// with mqtt.ErrNotConnected.
+func (b *Bridge) deliver(msg *mqtt.Message) error {
+	if b.outbox == nil {
+		return b.sendMessage(msg)
+	}
+	if held, err := b.outbox.Hold(msg); held {
+		return err
+	}
+	if !b.mqtt.Connected() {
+		return b.outbox.Put(msg)
+	}
+	err := b.sendMessage(msg)
+	if errors.Is(err, mqtt.ErrNotConnected) {
+		return b.outbox.Put(msg)
+	}
+	return err
+}
+
+// resync runs after a reconnect to the broker: it flushes the outbox and republishes every
+// cached state, since the broker may have lost its retained messages during the outage
+func (b *Bridge) resync() {
+	if b.outbox == nil {
+		return
+	}
+	n, err := b.outbox.Drain(b.sendMessage)
+	if err != nil {
+		slog.Warn("Failed to flush buffered messages", "flushed", n, "remaining", b.outbox.Len(), "error", err)
+		return
+	}
+	if n > 0 {
+		slog.Info("Flushed buffered messages", "count", n)
+	}
+	b.republishAll()
+}
@@ -0,0 +1,178 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func outboxMsg(topic, payload string) *mqtt.Message {
+	return &mqtt.Message{Topic: topic, Payload: []byte(payload), Retained: true}
+}
+
+func TestOutbox_LatestPerTopic(t *testing.T) {
+	o, err := newOutbox(2, "")
+	require.NoError(t, err)
+
+	require.NoError(t, o.Put(outboxMsg("a", "a1")))
+	require.NoError(t, o.Put(outboxMsg("b", "b1")))
+	require.NoError(t, o.Put(outboxMsg("a", "a2")), "a full buffer still takes newer values of its topics")
+	assert.ErrorIs(t, o.Put(outboxMsg("c", "c1")), errOutboxFull)
+
+	// Messages can't overtake the buffered ones
+	held, err := o.Hold(outboxMsg("b", "b2"))
+	assert.True(t, held)
+	assert.NoError(t, err)
+
+	sender := &recordingSender{}
+	n, err := o.Drain(sender.send)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"a2", "b2"}, sender.published(), "in buffering order, latest value only")
+
+	held, _ = o.Hold(outboxMsg("a", "a3"))
+	assert.False(t, held, "empty buffer sends right away")
+	assert.Equal(t, OutboxStats{Topics: 0, Capacity: 2, Flushed: 2, Dropped: 1}, o.Stats())
+}
+
+func TestOutbox_DrainFailure(t *testing.T) {
+	o, err := newOutbox(10, "")
+	require.NoError(t, err)
+	require.NoError(t, o.Put(outboxMsg("a", "a1")))
+	require.NoError(t, o.Put(outboxMsg("b", "b1")))
+
+	sender := &recordingSender{err: mqtt.ErrNotConnected}
+	n, err := o.Drain(sender.send)
+	assert.ErrorIs(t, err, mqtt.ErrNotConnected)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 2, o.Len(), "the failed message is kept")
+
+	sender.err = nil
+	n, err = o.Drain(sender.send)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 0, o.Len())
+}
+
+func TestOutbox_DrainUndeliverable(t *testing.T) {
+	o, err := newOutbox(10, "")
+	require.NoError(t, err)
+	require.NoError(t, o.Put(outboxMsg("a", "a1")))
+	require.NoError(t, o.Put(outboxMsg("b", "b1")))
+
+	var sent []string
+	n, err := o.Drain(func(msg *mqtt.Message) error {
+		if msg.Topic == "a" {
+			return errors.New("payload too large")
+		}
+		sent = append(sent, string(msg.Payload))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"b1"}, sent, "the undeliverable message doesn't hold back the others")
+	assert.Equal(t, OutboxStats{Topics: 0, Capacity: 10, Flushed: 1, Dropped: 1}, o.Stats())
+
+	held, _ := o.Hold(outboxMsg("a", "a2"))
+	assert.False(t, held)
+}
+
+func TestBridge_BufferWithoutConnection(t *testing.T) {
+	mockMQTT := new(MockMQTTProvider)
+	o, err := newOutbox(10, "")
+	require.NoError(t, err)
+	b := &Bridge{mqtt: mockMQTT, outbox: o}
+
+	// Buffered right away instead of waiting in the client's reconnect queue
+	mockMQTT.On("Connected").Return(false)
+	require.NoError(t, b.deliver(outboxMsg("a", "a1")))
+	assert.Equal(t, 1, o.Len())
+	mockMQTT.AssertNotCalled(t, "PublishMessage", mock.Anything)
+	mockMQTT.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestOutbox_Disk(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "buffer")
+	o, err := newOutbox(10, dir)
+	require.NoError(t, err)
+	require.NoError(t, o.Put(outboxMsg("lox/snr/room/light/switch_active", "1")))
+	require.NoError(t, o.Put(&mqtt.Message{Topic: "lox/snr/room/dimmer/dimmer_position", Payload: []byte("40"),
+		Properties: &mqtt.Properties{User: map[string]string{"room": "Room"}}}))
+	require.NoError(t, o.Put(outboxMsg("lox/snr/room/light/switch_active", "0")))
+
+	// A restarted bridge picks up the buffer
+	o, err = newOutbox(10, dir)
+	require.NoError(t, err)
+	require.Equal(t, 2, o.Len())
+
+	var sent []*mqtt.Message
+	_, err = o.Drain(func(msg *mqtt.Message) error {
+		sent = append(sent, msg)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, sent, 2)
+	assert.Equal(t, "40", string(sent[0].Payload))
+	assert.Equal(t, "Room", sent[0].Properties.User["room"])
+	assert.Equal(t, "0", string(sent[1].Payload))
+	assert.True(t, sent[1].Retained)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files, "flushed messages are removed from disk")
+}
+
+func TestBridge_BufferWhileDisconnected(t *testing.T) {
+	mockMQTT := new(MockMQTTProvider)
+	cfg := &config.Config{
+		Loxone: config.LoxoneConfig{Snr: "504F94A00000"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "loxone"},
+	}
+	formats, err := newPayloadFormats(cfg.Bridge)
+	require.NoError(t, err)
+	o, err := newOutbox(10, "")
+	require.NoError(t, err)
+
+	uuidStr := "10000000-0000-0000-0000000000000001"
+	structure := &loxone.LoxApp3{
+		Rooms: map[string]*loxone.Room{"r1": {Name: "Living Room", UUID: "r1"}},
+		Controls: map[string]*loxone.Control{
+			"c1": {Name: "Light", Room: "r1", Type: "Switch", States: map[string]interface{}{"active": uuidStr}},
+		},
+	}
+	b := &Bridge{cfg: cfg, mqtt: mockMQTT, registry: NewRegistry(structure, nil), formats: formats,
+		cache: NewStateCache(), outbox: o}
+
+	topic := "loxone/504F94A00000/living-room/light/switch_active"
+	// The connection is lost while the message is in flight
+	mockMQTT.On("Connected").Return(true).Once()
+	mockMQTT.On("Publish", topic, byte(0), true, mock.Anything).
+		Return(fmt.Errorf("%w: connection lost before Publish completed", mqtt.ErrNotConnected)).Once()
+	b.handleEvent(loxone.Event{UUID: uuidStr, Value: 1})
+	// Held behind the buffered value without trying the broker
+	b.handleEvent(loxone.Event{UUID: uuidStr, Value: 0})
+	assert.Equal(t, 1, o.Len())
+	mockMQTT.AssertNumberOfCalls(t, "Publish", 1)
+
+	// Reconnected: the buffered value is flushed, then all states are republished from the cache
+	var published []string
+	mockMQTT.On("Connected").Return(true)
+	mockMQTT.On("Publish", topic, byte(0), true, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		published = append(published, string(args.Get(3).([]byte)))
+	})
+	b.resync()
+	assert.Equal(t, 0, o.Len())
+	require.Len(t, published, 2)
+	for _, p := range published {
+		assert.Contains(t, p, `"value":0`)
+	}
+}
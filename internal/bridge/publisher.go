@@ -0,0 +1,272 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+	"github.com/google/uuid"
+)
+
+// publishDrainTimeout bounds how long Stop waits for queued states
+const publishDrainTimeout = 5 * time.Second
+
+var (
+	errPublishQueueFull = errors.New("publish queue full")
+	errPublisherStopped = errors.New("publisher stopped")
+)
+
+// publishJob is the pending publish of a state: its state topic and the optional UUID mirror
+type publishJob struct {
+	key  uuid.UUID
+	msgs []*mqtt.Message
+	done []func(err error) // Completion callbacks, kept when a newer value replaces the job
+}
+
+// publishShard is the queue of one worker. A state always maps to the same shard,
+// so its values are published in order.
+type publishShard struct {
+	order   []uuid.UUID
+	pending map[uuid.UUID]*publishJob
+	wake    chan struct{}
+}
+
+// PublishStats are the metrics of the publish pipeline
+type PublishStats struct {
+	Depth     int    `json:"depth"` // States waiting for a worker
+	Capacity  int    `json:"capacity"`
+	InFlight  int    `json:"inFlight"`
+	Published uint64 `json:"published"`
+	Coalesced uint64 `json:"coalesced"` // Replaced by a newer value of the same state before publishing
+	Dropped   uint64 `json:"dropped"`   // Rejected because the queue was full
+	Failed    uint64 `json:"failed"`
+}
+
+// publisher decouples state publishing from the event loop. Workers publish from bounded
+// queues; a state that is still queued is replaced by its latest value instead of queued twice.
+type publisher struct {
+	mu      sync.Mutex
+	shards  []*publishShard
+	send    func(msg *mqtt.Message) error
+	onError func(msg *mqtt.Message, err error)
+	stats   PublishStats
+	idle    chan struct{} // Closed when the pipeline drains, nil if nobody waits
+	stopped bool
+	quit    chan struct{}
+}
+
+func newPublisher(workers, size int, send func(msg *mqtt.Message) error, onError func(msg *mqtt.Message, err error)) *publisher {
+	if size < 1 {
+		size = 1
+	}
+	p := &publisher{
+		send:    send,
+		onError: onError,
+		stats:   PublishStats{Capacity: size},
+		quit:    make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		s := &publishShard{pending: make(map[uuid.UUID]*publishJob), wake: make(chan struct{}, 1)}
+		p.shards = append(p.shards, s)
+		go p.run(s)
+	}
+	return p
+}
+
+// Submit queues the messages of a state, done (optional) is called once they are published
+// or rejected. Returns false if the queue is full or the publisher is stopped.
+func (p *publisher) Submit(key uuid.UUID, msgs []*mqtt.Message, done func(err error)) bool {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		p.reject(msgs, done, errPublisherStopped)
+		return false
+	}
+
+	s := p.shards[binary.BigEndian.Uint32(key[12:])%uint32(len(p.shards))]
+	if job, ok := s.pending[key]; ok {
+		job.msgs = msgs
+		if done != nil {
+			job.done = append(job.done, done)
+		}
+		p.stats.Coalesced++
+		p.mu.Unlock()
+		return true
+	}
+
+	if p.stats.Depth >= p.stats.Capacity {
+		p.stats.Dropped++
+		p.mu.Unlock()
+		p.reject(msgs, done, errPublishQueueFull)
+		return false
+	}
+
+	job := &publishJob{key: key, msgs: msgs}
+	if done != nil {
+		job.done = append(job.done, done)
+	}
+	s.pending[key] = job
+	s.order = append(s.order, key)
+	p.stats.Depth++
+	p.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (p *publisher) reject(msgs []*mqtt.Message, done func(err error), err error) {
+	if p.onError != nil && len(msgs) > 0 {
+		p.onError(msgs[0], err)
+	}
+	if done != nil {
+		done(err)
+	}
+}
+
+func (p *publisher) run(s *publishShard) {
+	for {
+		select {
+		case <-p.quit:
+			return
+		default:
+		}
+
+		job := p.next(s)
+		if job == nil {
+			select {
+			case <-s.wake:
+			case <-p.quit:
+				return
+			}
+			continue
+		}
+		p.publish(job)
+	}
+}
+
+// next takes the oldest job of the shard, nil if it is empty
+func (p *publisher) next(s *publishShard) *publishJob {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(s.order) == 0 {
+		return nil
+	}
+	key := s.order[0]
+	s.order = s.order[1:]
+	job := s.pending[key]
+	delete(s.pending, key)
+	p.stats.Depth--
+	p.stats.InFlight++
+	return job
+}
+
+func (p *publisher) publish(job *publishJob) {
+	var err error
+	for _, msg := range job.msgs {
+		if e := p.send(msg); e != nil {
+			err = e
+			if p.onError != nil {
+				p.onError(msg, e)
+			}
+		}
+	}
+	for _, done := range job.done {
+		done(err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.InFlight--
+	if err != nil {
+		p.stats.Failed++
+	} else {
+		p.stats.Published++
+	}
+	if p.stats.Depth == 0 && p.stats.InFlight == 0 && p.idle != nil {
+		close(p.idle)
+		p.idle = nil
+	}
+}
+
+// Flush waits until all queued states are published, false on timeout
+func (p *publisher) Flush(timeout time.Duration) bool {
+	p.mu.Lock()
+	if p.stats.Depth == 0 && p.stats.InFlight == 0 {
+		p.mu.Unlock()
+		return true
+	}
+	if p.idle == nil {
+		p.idle = make(chan struct{})
+	}
+	idle := p.idle
+	p.mu.Unlock()
+
+	select {
+	case <-idle:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Stats returns a snapshot of the pipeline metrics
+func (p *publisher) Stats() PublishStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// Stop rejects new states, publishes the queued ones within the timeout and ends the workers
+func (p *publisher) Stop(timeout time.Duration) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	p.stopped = true
+	p.mu.Unlock()
+
+	if !p.Flush(timeout) {
+		slog.Warn("Publish queue not drained on shutdown", "depth", p.Stats().Depth)
+	}
+	close(p.quit)
+}
+
+// Metrics is the document published to <prefix>/_bridge/metrics
+type Metrics struct {
+	Publish PublishStats `json:"publish"`
+	Buffer  *OutboxStats `json:"buffer,omitempty"` // nil if the outbound buffer is disabled
+	Ts      string       `json:"ts"`
+}
+
+// metricsTopic is the bridge-level metrics topic: <prefix>/_bridge/metrics
+func (b *Bridge) metricsTopic() string {
+	return fmt.Sprintf("%s/_bridge/metrics", b.cfg.MQTT.TopicPrefix)
+}
+
+// publishMetrics publishes the pipeline metrics, retained so a dashboard sees the latest snapshot
+func (b *Bridge) publishMetrics() {
+	metrics := Metrics{
+		Publish: b.publisher.Stats(),
+		Ts:      time.Now().UTC().Format(time.RFC3339),
+	}
+	if b.outbox != nil {
+		stats := b.outbox.Stats()
+		metrics.Buffer = &stats
+	}
+	payload, _ := json.Marshal(metrics)
+	if err := b.mqtt.Publish(b.metricsTopic(), 0, true, payload); err != nil {
+		slog.Error("Failed to publish metrics", "error", err)
+	}
+}
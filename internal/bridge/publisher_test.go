@@ -0,0 +1,156 @@
+package bridge
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingSender records published payloads, blocking until released
+type recordingSender struct {
+	mu       sync.Mutex
+	payloads []string
+	release  chan struct{}
+	err      error
+}
+
+func (s *recordingSender) send(msg *mqtt.Message) error {
+	if s.release != nil {
+		<-s.release
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payloads = append(s.payloads, string(msg.Payload))
+	return s.err
+}
+
+func (s *recordingSender) published() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.payloads...)
+}
+
+func stateMsg(payload string) []*mqtt.Message {
+	return []*mqtt.Message{{Topic: "lox/state", Payload: []byte(payload)}}
+}
+
+func TestPublisher_Coalesce(t *testing.T) {
+	sender := &recordingSender{release: make(chan struct{})}
+	p := newPublisher(1, 10, sender.send, nil)
+	defer p.Stop(time.Second)
+
+	a, b := uuid.New(), uuid.New()
+	require.True(t, p.Submit(a, stateMsg("a1"), nil))
+	// Wait until the worker blocks on a1, the following states stay queued
+	require.Eventually(t, func() bool { return p.Stats().InFlight == 1 }, time.Second, time.Millisecond)
+
+	var completed []error
+	var mu sync.Mutex
+	done := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		completed = append(completed, err)
+	}
+	require.True(t, p.Submit(a, stateMsg("a2"), done))
+	require.True(t, p.Submit(b, stateMsg("b1"), nil))
+	require.True(t, p.Submit(a, stateMsg("a3"), done))
+
+	stats := p.Stats()
+	assert.Equal(t, 2, stats.Depth)
+	assert.Equal(t, uint64(1), stats.Coalesced)
+
+	close(sender.release)
+	require.True(t, p.Flush(time.Second))
+
+	// a2 is replaced by a3, the order of the queue is kept
+	assert.Equal(t, []string{"a1", "a3", "b1"}, sender.published())
+	assert.Equal(t, []error{nil, nil}, completed, "callbacks of a replaced value complete with the latest")
+	assert.Equal(t, uint64(3), p.Stats().Published)
+}
+
+func TestPublisher_Backpressure(t *testing.T) {
+	sender := &recordingSender{release: make(chan struct{})}
+	var rejected []error
+	p := newPublisher(1, 1, sender.send, func(msg *mqtt.Message, err error) {
+		rejected = append(rejected, err)
+	})
+
+	require.True(t, p.Submit(uuid.New(), stateMsg("1"), nil))
+	require.Eventually(t, func() bool { return p.Stats().InFlight == 1 }, time.Second, time.Millisecond)
+	require.True(t, p.Submit(uuid.New(), stateMsg("2"), nil))
+
+	var doneErr error
+	assert.False(t, p.Submit(uuid.New(), stateMsg("3"), func(err error) { doneErr = err }))
+	assert.ErrorIs(t, doneErr, errPublishQueueFull)
+	assert.Equal(t, []error{errPublishQueueFull}, rejected)
+	assert.Equal(t, uint64(1), p.Stats().Dropped)
+
+	close(sender.release)
+	p.Stop(time.Second)
+	assert.Equal(t, []string{"1", "2"}, sender.published())
+	assert.False(t, p.Submit(uuid.New(), stateMsg("4"), nil), "stopped publisher rejects states")
+}
+
+func TestPublisher_Errors(t *testing.T) {
+	sender := &recordingSender{err: errors.New("not connected")}
+	var failed []string
+	var mu sync.Mutex
+	p := newPublisher(2, 10, sender.send, func(msg *mqtt.Message, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, msg.Topic)
+	})
+	defer p.Stop(time.Second)
+
+	errs := make(chan error, 1)
+	p.Submit(uuid.New(), []*mqtt.Message{{Topic: "a"}, {Topic: "b"}}, func(err error) { errs <- err })
+	assert.EqualError(t, <-errs, "not connected")
+	require.True(t, p.Flush(time.Second))
+
+	assert.Equal(t, []string{"a", "b"}, failed)
+	assert.Equal(t, uint64(1), p.Stats().Failed)
+}
+
+func TestBridge_AsyncPublish(t *testing.T) {
+	mockMQTT := new(MockMQTTProvider)
+	cfg := &config.Config{
+		Loxone: config.LoxoneConfig{Snr: "504F94A00000"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "loxone"},
+	}
+
+	uuidStr := "10000000-0000-0000-0000000000000001"
+	structure := &loxone.LoxApp3{
+		Rooms: map[string]*loxone.Room{"r1": {Name: "Living Room", UUID: "r1"}},
+		Controls: map[string]*loxone.Control{
+			"c1": {Name: "Light", Room: "r1", Type: "Switch", States: map[string]interface{}{"active": uuidStr}},
+		},
+	}
+	formats, err := newPayloadFormats(cfg.Bridge)
+	require.NoError(t, err)
+
+	b := &Bridge{cfg: cfg, mqtt: mockMQTT, registry: NewRegistry(structure, nil), formats: formats}
+	b.publisher = newPublisher(1, 10, b.sendMessage, nil)
+
+	topic := "loxone/504F94A00000/living-room/light/switch_active"
+	mockMQTT.On("Publish", topic, byte(0), true, mock.Anything).Return(nil).Once()
+	b.handleEvent(loxone.Event{UUID: uuidStr, Value: 1})
+
+	b.publisher.Stop(time.Second)
+	mockMQTT.AssertExpectations(t)
+
+	mockMQTT.On("Publish", "loxone/_bridge/metrics", byte(0), true, mock.MatchedBy(func(p []byte) bool {
+		return strings.Contains(string(p), `"published":1`)
+	})).Return(nil).Once()
+	b.publishMetrics()
+	mockMQTT.AssertExpectations(t)
+}
@@ -0,0 +1,154 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+)
+
+// queuedCommand is a validated command waiting for the Loxone connection
+type queuedCommand struct {
+	req      *mqtt.Message // Command message, the result is published to <topic>/result or its response topic
+	ctrl     *loxone.Control
+	cmd      string // Loxone notation, see BuildCommand
+	deadline time.Time
+	expiry   *time.Timer
+}
+
+// commandQueue holds commands while Loxone is disconnected. It is bounded, every command
+// expires after its TTL, and a newer command for a control replaces the queued ones.
+type commandQueue struct {
+	mu      sync.Mutex
+	size    int
+	items   []*queuedCommand
+	expired func(c *queuedCommand)
+	stopped bool
+}
+
+func newCommandQueue(size int, expired func(c *queuedCommand)) *commandQueue {
+	return &commandQueue{size: size, expired: expired}
+}
+
+// Push queues a command and returns the commands of the same control it supersedes
+func (q *commandQueue) Push(c *queuedCommand) ([]*queuedCommand, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return nil, fmt.Errorf("command queue stopped")
+	}
+
+	var superseded []*queuedCommand
+	kept := q.items[:0]
+	for _, item := range q.items {
+		if item.ctrl == c.ctrl {
+			item.expiry.Stop()
+			superseded = append(superseded, item)
+			continue
+		}
+		kept = append(kept, item)
+	}
+	q.items = kept
+
+	if len(q.items) >= q.size {
+		return superseded, fmt.Errorf("command queue full (%d commands)", q.size)
+	}
+	q.arm(c)
+	q.items = append(q.items, c)
+	return superseded, nil
+}
+
+// Pop removes the oldest command
+func (q *commandQueue) Pop() (*queuedCommand, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return nil, false
+	}
+	c := q.items[0]
+	q.items = q.items[1:]
+	c.expiry.Stop()
+	return c, true
+}
+
+// PushFront returns a popped command that could not be sent, keeping its deadline
+func (q *commandQueue) PushFront(c *queuedCommand) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return
+	}
+	q.arm(c)
+	q.items = append([]*queuedCommand{c}, q.items...)
+}
+
+// Len returns the number of queued commands
+func (q *commandQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// arm starts the expiry timer of a command, the caller holds q.mu
+func (q *commandQueue) arm(c *queuedCommand) {
+	c.expiry = time.AfterFunc(time.Until(c.deadline), func() {
+		q.mu.Lock()
+		found := false
+		for i, item := range q.items {
+			if item == c {
+				q.items = append(q.items[:i], q.items[i+1:]...)
+				found = true
+				break
+			}
+		}
+		q.mu.Unlock()
+		if found {
+			q.expired(c)
+		}
+	})
+}
+
+// Stop cancels all expiry timers, queued commands are discarded
+func (q *commandQueue) Stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stopped = true
+	for _, item := range q.items {
+		item.expiry.Stop()
+	}
+	q.items = nil
+}
+
+// commandTTL returns the "ttl" of a JSON command payload ("10s" or seconds), 0 if not set
+func commandTTL(payload []byte) (time.Duration, error) {
+	raw := strings.TrimSpace(string(payload))
+	if !strings.HasPrefix(raw, "{") {
+		return 0, nil
+	}
+	var c struct {
+		TTL interface{} `json:"ttl"`
+	}
+	if err := json.Unmarshal([]byte(raw), &c); err != nil {
+		return 0, nil // Reported by BuildCommand
+	}
+	switch v := c.TTL.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		if v <= 0 {
+			return 0, fmt.Errorf("invalid ttl %v", v)
+		}
+		return time.Duration(v * float64(time.Second)), nil
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid ttl %q (use e.g. 10s)", v)
+		}
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid ttl %v", c.TTL)
+}
@@ -0,0 +1,75 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandQueue(t *testing.T) {
+	blind := &loxone.Control{Name: "Blind"}
+	light := &loxone.Control{Name: "Light"}
+
+	expired := make(chan *queuedCommand, 1)
+	q := newCommandQueue(2, func(c *queuedCommand) { expired <- c })
+	defer q.Stop()
+
+	deadline := time.Now().Add(time.Minute)
+	_, err := q.Push(&queuedCommand{ctrl: blind, cmd: "up", deadline: deadline})
+	require.NoError(t, err)
+	_, err = q.Push(&queuedCommand{ctrl: light, cmd: "On", deadline: deadline})
+	require.NoError(t, err)
+
+	// Full
+	_, err = q.Push(&queuedCommand{ctrl: &loxone.Control{}, cmd: "x", deadline: deadline})
+	assert.Error(t, err)
+
+	// A newer intent for the blind replaces the queued one and goes to the back
+	superseded, err := q.Push(&queuedCommand{ctrl: blind, cmd: "stop", deadline: deadline})
+	require.NoError(t, err)
+	require.Len(t, superseded, 1)
+	assert.Equal(t, "up", superseded[0].cmd)
+
+	c, ok := q.Pop()
+	require.True(t, ok)
+	assert.Equal(t, "On", c.cmd)
+	q.PushFront(c)
+	assert.Equal(t, 2, q.Len())
+
+	var order []string
+	for c, ok := q.Pop(); ok; c, ok = q.Pop() {
+		order = append(order, c.cmd)
+	}
+	assert.Equal(t, []string{"On", "stop"}, order)
+
+	// Expiry removes and reports the command
+	_, err = q.Push(&queuedCommand{ctrl: light, cmd: "Off", deadline: time.Now().Add(10 * time.Millisecond)})
+	require.NoError(t, err)
+	select {
+	case c := <-expired:
+		assert.Equal(t, "Off", c.cmd)
+	case <-time.After(time.Second):
+		t.Fatal("command did not expire")
+	}
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestCommandTTL(t *testing.T) {
+	ttl, err := commandTTL([]byte(`{"cmd":"up","ttl":"10s"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, ttl)
+
+	ttl, err = commandTTL([]byte(`{"cmd":"up","ttl":2.5}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 2500*time.Millisecond, ttl)
+
+	ttl, err = commandTTL([]byte("up"))
+	assert.NoError(t, err)
+	assert.Zero(t, ttl)
+
+	_, err = commandTTL([]byte(`{"cmd":"up","ttl":"soon"}`))
+	assert.Error(t, err)
+}
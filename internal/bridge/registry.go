@@ -3,8 +3,10 @@ package bridge
 import (
 	"fmt"
 	"log/slog"
+	"sort"
 	"strings"
 
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
 	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
 	"github.com/google/uuid"
 )
@@ -13,8 +15,13 @@ import (
 type Registry struct {
 	states        map[uuid.UUID]State
 	rooms         map[string]*loxone.Room
-	lookup        map[string]uuid.UUID       // Key: "room/control/function"
-	controlLookup map[string]*loxone.Control // Key: "room/control"
+	cats          map[string]*loxone.Cat
+	aliases       map[uuid.UUID]config.ControlMapping
+	lookup        map[string]uuid.UUID       // Key: "room/control/state" (slugs)
+	controlLookup map[string]*loxone.Control // Key: "room/control" (slugs)
+	controlStates map[*loxone.Control][]uuid.UUID
+	controlPaths  map[*loxone.Control]string
+	actions       map[uuid.UUID]*loxone.Control // Key: UUIDAction
 }
 
 // State represents a specific state of a control (e.g. "value", "temp", "active")
@@ -23,19 +30,60 @@ type State struct {
 	Name     string
 	UUID     uuid.UUID
 	RoomName string
+	Index    int          // Position within an array-valued state, -1 for scalar states
+	Format   *ValueFormat // Display format from the control details, nil if none applies
+
+	// Slugs used to build topics, either sanitized names or mapping overrides
+	RoomSlug    string
+	ControlSlug string
+	StateSlug   string
+}
+
+// Segment returns the last topic level of the state: <type>_<state>
+func (s *State) Segment() string {
+	return fmt.Sprintf("%s_%s", sanitize(s.Control.Type), s.StateSlug)
+}
+
+// Classes returns the configuration keys of the state, most specific first: "<type>_<state>", "<type>".
+// They use the Loxone names, so mapping overrides don't change which settings apply.
+func (s *State) Classes() []string {
+	ctrlType := sanitize(s.Control.Type)
+	return []string{fmt.Sprintf("%s_%s", ctrlType, sanitize(s.Name)), ctrlType}
 }
 
-// NewRegistry creates a new Registry and processes the structure
-func NewRegistry(structure *loxone.LoxApp3) *Registry {
+// Path returns the topic path below <prefix>/<snr>: <room>/<control>/<type>_<state>
+func (s *State) Path() string {
+	return fmt.Sprintf("%s/%s/%s", s.RoomSlug, s.ControlSlug, s.Segment())
+}
+
+// NewRegistry creates a new Registry and processes the structure.
+// The mapping is optional and overrides slugs for the controls it lists.
+func NewRegistry(structure *loxone.LoxApp3, mapping *config.Mapping) *Registry {
 	r := &Registry{
 		states:        make(map[uuid.UUID]State),
 		rooms:         make(map[string]*loxone.Room),
+		aliases:       make(map[uuid.UUID]config.ControlMapping),
 		lookup:        make(map[string]uuid.UUID),
 		controlLookup: make(map[string]*loxone.Control),
+		controlStates: make(map[*loxone.Control][]uuid.UUID),
+		controlPaths:  make(map[*loxone.Control]string),
+		actions:       make(map[uuid.UUID]*loxone.Control),
+	}
+
+	if mapping != nil {
+		for id, cm := range mapping.Controls {
+			u, err := ParseUUID(id)
+			if err != nil {
+				slog.Warn("Ignoring mapping with invalid control UUID", "uuid", id, "error", err)
+				continue
+			}
+			r.aliases[u] = cm
+		}
 	}
 
 	if structure != nil {
 		r.rooms = structure.Rooms
+		r.cats = structure.Cats
 		r.processControls(structure.Controls)
 	}
 
@@ -61,52 +109,62 @@ func (r *Registry) processControls(controls map[string]*loxone.Control) {
 			roomName = room.Name
 		}
 
+		alias := r.aliasFor(ctrl)
+		roomSlug := slugOr(alias.Room, roomName)
+		ctrlSlug := slugOr(alias.Control, ctrl.Name)
+
 		// Populate control lookup
-		// Key format: sanitized room/control
-		ctrlKey := fmt.Sprintf("%s/%s", sanitize(roomName), sanitize(ctrl.Name))
+		// Key format: room/control slugs
+		ctrlKey := fmt.Sprintf("%s/%s", roomSlug, ctrlSlug)
+		if existing, ok := r.controlLookup[ctrlKey]; ok && existing != ctrl {
+			slog.Warn("Topic path collision, control will shadow another", "path", ctrlKey, "control", ctrl.Name, "shadowed", existing.Name)
+		}
 		r.controlLookup[ctrlKey] = ctrl
+		r.controlPaths[ctrl] = ctrlKey
+		if u, err := ParseUUID(ctrl.UUIDAction); err == nil {
+			r.actions[u] = ctrl
+		}
 
 		for stateName, uuidVal := range ctrl.States {
+			state := State{
+				Control:     ctrl,
+				Name:        stateName,
+				RoomName:    roomName,
+				Index:       -1,
+				Format:      formatOf(ctrl, stateName),
+				RoomSlug:    roomSlug,
+				ControlSlug: ctrlSlug,
+				StateSlug:   slugOr(alias.States[stateName], stateName),
+			}
+
 			switch v := uuidVal.(type) {
 			case string:
 				u, err := ParseUUID(v)
 				if err == nil {
-					state := State{
-						Control:  ctrl,
-						Name:     stateName,
-						UUID:     u,
-						RoomName: roomName,
-					}
-					r.states[u] = state
-
-					// Populate lookup map
-					// Key format: sanitized room/control/function
-					key := fmt.Sprintf("%s/%s/%s", sanitize(roomName), sanitize(ctrl.Name), stateName)
-					r.lookup[key] = u
+					state.UUID = u
+					r.addState(state)
 				} else {
 					slog.Warn("Failed to parse UUID", "control", ctrl.Name, "state", stateName, "value", v, "error", err)
 				}
 			case []interface{}:
-				for _, item := range v {
-					if s, ok := item.(string); ok {
-						u, err := ParseUUID(s)
-						if err == nil {
-							state := State{
-								Control:  ctrl,
-								Name:     stateName,
-								UUID:     u,
-								RoomName: roomName,
-							}
-							r.states[u] = state
-
-							// For arrays, we might need a strategy. For now, we overwrite or ignore.
-							// Usually these are alternative UUIDs or specific sub-functions.
-							// Let's index the first one or all if possible.
-							// Given the 1:1 map, last one wins.
-							key := fmt.Sprintf("%s/%s/%s", sanitize(roomName), sanitize(ctrl.Name), stateName)
-							r.lookup[key] = u
-						}
+				// Array states (e.g. SolarPumpController bufferTemp) carry one UUID per output,
+				// each gets its own indexed slug so they don't overwrite each other.
+				baseSlug := state.StateSlug
+				for i, item := range v {
+					s, ok := item.(string)
+					if !ok {
+						continue
+					}
+					u, err := ParseUUID(s)
+					if err != nil {
+						slog.Warn("Failed to parse UUID", "control", ctrl.Name, "state", stateName, "index", i, "value", s, "error", err)
+						continue
 					}
+					indexed := state
+					indexed.UUID = u
+					indexed.Index = i
+					indexed.StateSlug = fmt.Sprintf("%s_%d", baseSlug, i)
+					r.addState(indexed)
 				}
 			}
 		}
@@ -117,6 +175,27 @@ func (r *Registry) processControls(controls map[string]*loxone.Control) {
 	}
 }
 
+func (r *Registry) addState(s State) {
+	r.states[s.UUID] = s
+	r.controlStates[s.Control] = append(r.controlStates[s.Control], s.UUID)
+
+	// Key format: room/control/state slugs
+	key := fmt.Sprintf("%s/%s/%s", s.RoomSlug, s.ControlSlug, s.StateSlug)
+	r.lookup[key] = s.UUID
+}
+
+// aliasFor returns the mapping overrides of a control, empty if none are configured
+func (r *Registry) aliasFor(ctrl *loxone.Control) config.ControlMapping {
+	if len(r.aliases) == 0 || ctrl.UUIDAction == "" {
+		return config.ControlMapping{}
+	}
+	u, err := ParseUUID(ctrl.UUIDAction)
+	if err != nil {
+		return config.ControlMapping{}
+	}
+	return r.aliases[u]
+}
+
 func ParseUUID(s string) (uuid.UUID, error) {
 	// Loxone UUIDs in LoxAPP3.json often use 8-4-4-16 format (35 chars)
 	// or 8-4-4-4-12 (36 chars).
@@ -139,10 +218,11 @@ func (r *Registry) LookupState(u uuid.UUID) (*State, bool) {
 	return &s, true
 }
 
-// LookupStateByPath finds a State by room, control, and function name
+// LookupStateByPath finds a State by room, control, and function name.
+// States of array-valued functions are addressed with their index suffix, e.g. "buffertemp_1".
 func (r *Registry) LookupStateByPath(room, control, function string) (*State, bool) {
 	// keys are stored sanitized
-	key := fmt.Sprintf("%s/%s/%s", sanitize(room), sanitize(control), function)
+	key := fmt.Sprintf("%s/%s/%s", sanitize(room), sanitize(control), sanitize(function))
 	u, ok := r.lookup[key]
 	if !ok {
 		return nil, false
@@ -150,6 +230,109 @@ func (r *Registry) LookupStateByPath(room, control, function string) (*State, bo
 	return r.LookupState(u)
 }
 
+// LookupStateBySegment finds a state by the levels of its topic: room, control and <type>_<state>
+func (r *Registry) LookupStateBySegment(room, control, segment string) (*State, bool) {
+	ctrl, ok := r.LookupControlByPath(room, control)
+	if !ok {
+		return nil, false
+	}
+	prefix := sanitize(ctrl.Type) + "_"
+	segment = sanitize(segment)
+	if !strings.HasPrefix(segment, prefix) {
+		return nil, false
+	}
+	state, ok := r.LookupStateByPath(room, control, strings.TrimPrefix(segment, prefix))
+	if !ok || state.Control != ctrl {
+		return nil, false
+	}
+	return state, true
+}
+
+// LookupIndexedStateByPath finds one element of an array-valued state
+func (r *Registry) LookupIndexedStateByPath(room, control, function string, index int) (*State, bool) {
+	return r.LookupStateByPath(room, control, fmt.Sprintf("%s_%d", function, index))
+}
+
+// StatesOf returns all states of a control ordered by name and index
+func (r *Registry) StatesOf(ctrl *loxone.Control) []State {
+	uuids := r.controlStates[ctrl]
+	states := make([]State, 0, len(uuids))
+	for _, u := range uuids {
+		states = append(states, r.states[u])
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Name != states[j].Name {
+			return states[i].Name < states[j].Name
+		}
+		return states[i].Index < states[j].Index
+	})
+	return states
+}
+
+// StateTopics maps each state name of a control to its topic segment, or a list of segments for array states
+func (r *Registry) StateTopics(ctrl *loxone.Control) map[string]interface{} {
+	topics := make(map[string]interface{})
+	for _, s := range r.StatesOf(ctrl) {
+		segment := s.Segment()
+		if s.Index < 0 {
+			topics[s.Name] = segment
+			continue
+		}
+		list, _ := topics[s.Name].([]string)
+		topics[s.Name] = append(list, segment)
+	}
+	return topics
+}
+
+// SetTopics lists the topic segments of a control's writable states, see SetterOf
+func (r *Registry) SetTopics(ctrl *loxone.Control) []string {
+	var segments []string
+	for _, s := range r.StatesOf(ctrl) {
+		if _, ok := SetterOf(&s); ok {
+			segments = append(segments, s.Segment())
+		}
+	}
+	return segments
+}
+
+// RoomOf returns the room name of a control, empty if the room is unknown
+func (r *Registry) RoomOf(ctrl *loxone.Control) string {
+	if room, ok := r.rooms[ctrl.Room]; ok {
+		return room.Name
+	}
+	return ""
+}
+
+// CategoryOf returns the category name of a control, empty if the category is unknown
+func (r *Registry) CategoryOf(ctrl *loxone.Control) string {
+	if cat, ok := r.cats[ctrl.Cat]; ok {
+		return cat.Name
+	}
+	return ""
+}
+
+// ControlPath returns the topic path of a control below <prefix>/<snr>: <room>/<control>
+func (r *Registry) ControlPath(ctrl *loxone.Control) (string, bool) {
+	p, ok := r.controlPaths[ctrl]
+	return p, ok
+}
+
+// LookupControlByAction finds a Control by its UUIDAction in either UUID notation
+func (r *Registry) LookupControlByAction(s string) (*loxone.Control, bool) {
+	u, err := ParseUUID(s)
+	if err != nil {
+		return nil, false
+	}
+	c, ok := r.actions[u]
+	return c, ok
+}
+
+// LoxoneUUID formats a UUID in the 8-4-4-16 notation of the structure file
+func LoxoneUUID(u uuid.UUID) string {
+	s := u.String()
+	return s[:23] + s[24:]
+}
+
 // LookupControlByPath finds a Control by room and control name
 func (r *Registry) LookupControlByPath(room, control string) (*loxone.Control, bool) {
 	key := fmt.Sprintf("%s/%s", sanitize(room), sanitize(control))
@@ -157,6 +340,14 @@ func (r *Registry) LookupControlByPath(room, control string) (*loxone.Control, b
 	return c, ok
 }
 
+// slugOr returns the sanitized alias if set, otherwise the sanitized name
+func slugOr(alias, name string) string {
+	if alias != "" {
+		return sanitize(alias)
+	}
+	return sanitize(name)
+}
+
 // sanitize replaces spaces with hyphens and lowercases the string
 func sanitize(s string) string {
 	s = strings.ToLower(s)
@@ -3,6 +3,7 @@ package bridge
 import (
 	"testing"
 
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
 	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
 	"github.com/stretchr/testify/assert"
 )
@@ -87,7 +88,7 @@ func TestRegistry_ProcessControls(t *testing.T) {
 	}
 
 	// Initialize Registry
-	registry := NewRegistry(structure)
+	registry := NewRegistry(structure, nil)
 
 	tests := []struct {
 		name         string
@@ -206,7 +207,7 @@ func TestRegistry_LookupByPath(t *testing.T) {
 			},
 		},
 	}
-	registry := NewRegistry(structure)
+	registry := NewRegistry(structure, nil)
 
 	// Registry sanitizes names: "Living Room" -> "living-room", "Light" -> "light"
 	state, found := registry.LookupStateByPath("Living Room", "Light", "active")
@@ -223,3 +224,113 @@ func TestRegistry_LookupByPath(t *testing.T) {
 	assert.True(t, found2)
 	assert.Equal(t, state, state2)
 }
+
+func TestRegistry_Mapping(t *testing.T) {
+	uuidAction := "10000000-0000-0000-0000000000000010"
+	uuidPos := "10000000-0000-0000-0000000000000011"
+	uuidOther := "10000000-0000-0000-0000000000000012"
+	structure := &loxone.LoxApp3{
+		Rooms: map[string]*loxone.Room{
+			"room1": {Name: "Wohnzimmer", UUID: "room1"},
+		},
+		Controls: map[string]*loxone.Control{
+			uuidAction: {
+				Name:       "Deckenlicht",
+				Type:       "Dimmer",
+				UUIDAction: uuidAction,
+				Room:       "room1",
+				States: map[string]interface{}{
+					"position": uuidPos,
+				},
+			},
+			"c2": {
+				Name:       "Steckdose",
+				Type:       "Switch",
+				UUIDAction: "20000000-0000-0000-0000000000000010",
+				Room:       "room1",
+				States: map[string]interface{}{
+					"active": uuidOther,
+				},
+			},
+		},
+	}
+	mapping := &config.Mapping{
+		Controls: map[string]config.ControlMapping{
+			// Different UUID notation than in the structure file
+			"10000000-0000-0000-0000-000000000010": {
+				Room:    "Living Room",
+				Control: "ceiling-light",
+				States:  map[string]string{"position": "level"},
+			},
+		},
+	}
+	registry := NewRegistry(structure, mapping)
+
+	u, _ := ParseUUID(uuidPos)
+	state, found := registry.LookupState(u)
+	assert.True(t, found)
+	assert.Equal(t, "living-room/ceiling-light/dimmer_level", state.Path())
+	// Loxone names are kept for metadata
+	assert.Equal(t, "Wohnzimmer", state.RoomName)
+	assert.Equal(t, "position", state.Name)
+
+	_, found = registry.LookupStateByPath("living-room", "ceiling-light", "level")
+	assert.True(t, found)
+	_, found = registry.LookupStateByPath("wohnzimmer", "deckenlicht", "position")
+	assert.False(t, found)
+
+	ctrl, found := registry.LookupControlByPath("living-room", "ceiling-light")
+	assert.True(t, found)
+	assert.Equal(t, "Deckenlicht", ctrl.Name)
+
+	// Unmapped controls keep their sanitized names
+	u, _ = ParseUUID(uuidOther)
+	state, found = registry.LookupState(u)
+	assert.True(t, found)
+	assert.Equal(t, "wohnzimmer/steckdose/switch_active", state.Path())
+}
+
+func TestRegistry_ArrayStates(t *testing.T) {
+	uuidBuffer0 := "10000000-0000-0000-0000000000000021"
+	uuidBuffer1 := "10000000-0000-0000-0000000000000022"
+	uuidCollector := "10000000-0000-0000-0000000000000023"
+	ctrl := &loxone.Control{
+		Name: "Solar",
+		Type: "SolarPumpController",
+		Room: "room1",
+		States: map[string]interface{}{
+			"bufferTemp":    []interface{}{uuidBuffer0, uuidBuffer1},
+			"collectorTemp": uuidCollector,
+		},
+	}
+	structure := &loxone.LoxApp3{
+		Rooms:    map[string]*loxone.Room{"room1": {Name: "Basement", UUID: "room1"}},
+		Controls: map[string]*loxone.Control{"c1": ctrl},
+	}
+	registry := NewRegistry(structure, nil)
+
+	for i, id := range []string{uuidBuffer0, uuidBuffer1} {
+		u, _ := ParseUUID(id)
+		state, found := registry.LookupState(u)
+		assert.True(t, found)
+		assert.Equal(t, "bufferTemp", state.Name)
+		assert.Equal(t, i, state.Index)
+
+		byPath, found := registry.LookupIndexedStateByPath("Basement", "Solar", "bufferTemp", i)
+		assert.True(t, found)
+		assert.Equal(t, u, byPath.UUID)
+	}
+
+	state, found := registry.LookupStateByPath("basement", "solar", "buffertemp_1")
+	assert.True(t, found)
+	assert.Equal(t, "basement/solar/solarpumpcontroller_buffertemp_1", state.Path())
+
+	state, found = registry.LookupStateByPath("basement", "solar", "collectortemp")
+	assert.True(t, found)
+	assert.Equal(t, -1, state.Index)
+
+	assert.Equal(t, map[string]interface{}{
+		"bufferTemp":    []string{"solarpumpcontroller_buffertemp_0", "solarpumpcontroller_buffertemp_1"},
+		"collectorTemp": "solarpumpcontroller_collectortemp",
+	}, registry.StateTopics(ctrl))
+}
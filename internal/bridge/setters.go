@@ -0,0 +1,183 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTempOverride is used when BRIDGE_TEMP_OVERRIDE is not set
+const defaultTempOverride = time.Hour
+
+// setRequest is a value written to a .../<type>_<state>/set topic
+type setRequest struct {
+	Value        interface{}   // float64, bool or string
+	Now          time.Time     // In the Miniserver's zone, see Bridge.loxoneNow
+	TempOverride time.Duration // Duration of temperature overrides, e.g. IRC tempTarget
+}
+
+// StateSetter translates a value written to a state's set topic into a command in Loxone
+// notation; the result is validated by BuildCommand like any other command.
+type StateSetter func(r setRequest) (string, error)
+
+// stateSetters is keyed by control type and Loxone state name. Values use the same scale
+// as the state topic, e.g. Jalousie position 0..1 is sent as manualPosition 0..100.
+var stateSetters = map[string]map[string]StateSetter{
+	"AudioZoneV2": {"volume": setNumber("volume", 1)},
+	"ColorPickerV2": {
+		"color": setText(""), // hsv(h,s,v) or temp(b,t) as published
+	},
+	"Dimmer": {"position": setNumber("", 1)},
+	"IRoomControllerV2": {
+		"tempTarget":         setTempOverride,
+		"comfortTemperature": setNumber("setComfortTemperature", 1),
+		"operatingMode":      setNumber("setOperatingMode", 1),
+	},
+	"Jalousie": {
+		"position":      setNumber("manualPosition", 100),
+		"shadePosition": setNumber("manualLamelle", 100),
+	},
+	"LightControllerV2": {"presence": setBool("presence/on", "presence/off")},
+	"Radio":             {"activeOutput": setRadio},
+	"Sauna": {
+		"active":     setBool("on", "off"),
+		"fan":        setBool("fanon", "fanoff"),
+		"tempTarget": setNumber("temp", 1),
+	},
+	"Switch":        {"active": setBool("On", "Off")},
+	"TextInput":     {"text": setText("")},
+	"ValueSelector": {"value": setNumber("", 1)},
+	"Window":        {"position": setNumber("moveToPosition", 100)},
+}
+
+// SetterOf returns the setter of a state, false if the state is read only
+func SetterOf(s *State) (StateSetter, bool) {
+	setter, ok := stateSetters[s.Control.Type][s.Name]
+	return setter, ok
+}
+
+// parseSetValue accepts a raw value ("50", "true", "ON") or the JSON envelope of the state topic ({"value": 50})
+func parseSetValue(payload []byte) (interface{}, error) {
+	raw := strings.TrimSpace(string(payload))
+	if raw == "" {
+		return nil, fmt.Errorf("empty value")
+	}
+	if strings.HasPrefix(raw, "{") {
+		var p struct {
+			Value interface{} `json:"value"`
+		}
+		if err := json.Unmarshal([]byte(raw), &p); err != nil {
+			return nil, fmt.Errorf("invalid JSON value: %v", err)
+		}
+		if p.Value == nil {
+			return nil, fmt.Errorf("missing value")
+		}
+		return p.Value, nil
+	}
+	return raw, nil
+}
+
+func toNumber(v interface{}) (float64, error) {
+	switch val := v.(type) {
+	case float64:
+		return val, nil
+	case bool:
+		if val {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", val)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+func toBool(v interface{}) (bool, error) {
+	switch val := v.(type) {
+	case bool:
+		return val, nil
+	case float64:
+		return val != 0, nil
+	case string:
+		switch strings.ToLower(val) {
+		case "1", "true", "on":
+			return true, nil
+		case "0", "false", "off":
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("%v is not a boolean", v)
+}
+
+// setNumber sends "<name>/<value*scale>", or the bare value if name is empty
+func setNumber(name string, scale float64) StateSetter {
+	return func(r setRequest) (string, error) {
+		f, err := toNumber(r.Value)
+		if err != nil {
+			return "", err
+		}
+		value := strconv.FormatFloat(f*scale, 'f', -1, 64)
+		if name == "" {
+			return value, nil
+		}
+		return name + "/" + value, nil
+	}
+}
+
+// setBool sends one of two commands depending on the value
+func setBool(on, off string) StateSetter {
+	return func(r setRequest) (string, error) {
+		b, err := toBool(r.Value)
+		if err != nil {
+			return "", err
+		}
+		if b {
+			return on, nil
+		}
+		return off, nil
+	}
+}
+
+// setText sends the value as text argument, escaped by BuildCommand
+func setText(name string) StateSetter {
+	return func(r setRequest) (string, error) {
+		s := rawString(r.Value)
+		if name == "" {
+			return s, nil
+		}
+		return name + "/" + s, nil
+	}
+}
+
+// setRadio selects an output by ID, 0 deselects
+func setRadio(r setRequest) (string, error) {
+	f, err := toNumber(r.Value)
+	if err != nil {
+		return "", err
+	}
+	if f == 0 {
+		return "reset", nil
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64), nil
+}
+
+// setTempOverride starts a manual override (mode 3) with the target temperature,
+// ending after r.TempOverride (Loxone time, local seconds since 2009)
+func setTempOverride(r setRequest) (string, error) {
+	temp, err := toNumber(r.Value)
+	if err != nil {
+		return "", err
+	}
+	d := r.TempOverride
+	if d <= 0 {
+		d = defaultTempOverride
+	}
+	until := loxoneSeconds(r.Now.Add(d))
+	return fmt.Sprintf("override/3/%d/%s", until, strconv.FormatFloat(temp, 'f', -1, 64)), nil
+}
@@ -0,0 +1,76 @@
+package bridge
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/loxone"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateSetters(t *testing.T) {
+	now := time.Date(2024, 10, 4, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		typ     string
+		state   string
+		payload string
+		want    string
+		wantErr bool
+	}{
+		{"Switch on", "Switch", "active", "1", "On", false},
+		{"Switch off text", "Switch", "active", "OFF", "Off", false},
+		{"Switch JSON bool", "Switch", "active", `{"value":true}`, "On", false},
+		{"Dimmer position", "Dimmer", "position", "42", "42", false},
+		{"Jalousie scaled", "Jalousie", "position", "0.25", "manualPosition/25", false},
+		{"Lamelle scaled", "Jalousie", "shadePosition", "1", "manualLamelle/100", false},
+		{"IRC override", "IRoomControllerV2", "tempTarget", "21.5", "override/3/497264400/21.5", false},
+		{"Radio select", "Radio", "activeOutput", "2", "2", false},
+		{"Radio reset", "Radio", "activeOutput", "0", "reset", false},
+		{"Text escaped", "TextInput", "text", "hello world", "hello%20world", false},
+		{"Color", "ColorPickerV2", "color", "hsv(10,100,50)", "hsv(10,100,50)", false},
+		{"Not a number", "Jalousie", "position", "half", "", true},
+		{"Not a boolean", "Switch", "active", "maybe", "", true},
+		{"Empty", "Dimmer", "position", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &State{Control: &loxone.Control{Type: tt.typ}, Name: tt.state}
+			setter, ok := SetterOf(s)
+			assert.True(t, ok)
+
+			value, err := parseSetValue([]byte(tt.payload))
+			var cmd string
+			if err == nil {
+				cmd, err = setter(setRequest{Value: value, Now: now})
+			}
+			if err == nil {
+				cmd, err = BuildCommand(tt.typ, []byte(cmd))
+			}
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, cmd)
+		})
+	}
+
+	_, ok := SetterOf(&State{Control: &loxone.Control{Type: "Jalousie"}, Name: "up"})
+	assert.False(t, ok)
+}
+
+func TestSetTempOverride_LocalTime(t *testing.T) {
+	// 10:00 summer time in Zurich, the Miniserver counts local wall-clock seconds
+	zurich := time.FixedZone("CEST", 2*60*60)
+	now := time.Date(2024, 10, 4, 10, 0, 0, 0, zurich)
+
+	cmd, err := setTempOverride(setRequest{Value: 21.5, Now: now, TempOverride: time.Hour})
+	assert.NoError(t, err)
+	// Ends at 11:00 local time
+	until := time.Date(2024, 10, 4, 11, 0, 0, 0, time.UTC).Sub(loxoneEpoch)
+	assert.Equal(t, fmt.Sprintf("override/3/%d/21.5", int64(until.Seconds())), cmd)
+}
@@ -0,0 +1,79 @@
+package bridge
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/mqtt"
+)
+
+// Availability payloads of the status topics
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+)
+
+// statusTopic is the availability of the bridge for the Miniserver: <prefix>/<snr>/_status.
+// It is the MQTT will of the connection, so it turns offline when the bridge dies.
+func (b *Bridge) statusTopic() string {
+	return fmt.Sprintf("%s/%s/_status", b.cfg.MQTT.TopicPrefix, b.cfg.Loxone.Snr)
+}
+
+// bridgeStatusTopic is the bridge-level availability: <prefix>/_bridge/status. Not retained,
+// a connection has only one will and it can't reset this topic after a crash.
+func (b *Bridge) bridgeStatusTopic() string {
+	return fmt.Sprintf("%s/_bridge/status", b.cfg.MQTT.TopicPrefix)
+}
+
+// loxoneStatusTopic is the state of the Miniserver connection: <prefix>/<snr>/_status/loxone.
+// Not retained for the same reason as bridgeStatusTopic.
+func (b *Bridge) loxoneStatusTopic() string {
+	return b.statusTopic() + "/loxone"
+}
+
+// will is the message the broker publishes when the bridge disconnects unexpectedly. A connection
+// has only one will: _status "offline", unless BRIDGE_HOMIE_WILL opts into the Homie $state "lost".
+func (b *Bridge) will() *mqtt.Message {
+	if b.homieWill() {
+		return &mqtt.Message{Topic: b.homie.base() + "/$state", Payload: []byte(HomieLost), QoS: 1, Retained: true}
+	}
+	return &mqtt.Message{Topic: b.statusTopic(), Payload: []byte(StatusOffline), QoS: 1, Retained: true}
+}
+
+// homieWill reports whether the Homie $state replaces _status as will
+func (b *Bridge) homieWill() bool {
+	return b.homie != nil && b.cfg.Bridge.HomieWill
+}
+
+// publishStatus publishes an availability payload, retained only for topics covered by the will
+func (b *Bridge) publishStatus(topic, status string, retained bool) {
+	if err := b.mqtt.Publish(topic, 1, retained, status); err != nil {
+		slog.Error("Failed to publish status", "topic", topic, "error", err)
+	}
+}
+
+// onMQTTConnect replaces the will's offline with online after every (re)connect
+// and flushes the states buffered while the broker was unreachable
+func (b *Bridge) onMQTTConnect() {
+	b.publishStatus(b.statusTopic(), StatusOnline, true)
+	b.publishStatus(b.bridgeStatusTopic(), StatusOnline, false)
+	b.restoreHomieState()
+	b.resync()
+}
+
+// publishLoxoneStatus reports a change of the Miniserver connection
+func (b *Bridge) publishLoxoneStatus(connected bool) {
+	status := StatusOffline
+	if connected {
+		status = StatusOnline
+	}
+	b.publishStatus(b.loxoneStatusTopic(), status, false)
+}
+
+// publishOffline marks the bridge offline on shutdown, the broker does not send the will
+// for a clean disconnect
+func (b *Bridge) publishOffline() {
+	b.publishStatus(b.loxoneStatusTopic(), StatusOffline, false)
+	b.publishStatus(b.statusTopic(), StatusOffline, true)
+	b.publishStatus(b.bridgeStatusTopic(), StatusOffline, false)
+}
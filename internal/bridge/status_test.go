@@ -0,0 +1,41 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBridge_Status(t *testing.T) {
+	mockMQTT := new(MockMQTTProvider)
+	cfg := &config.Config{
+		Loxone: config.LoxoneConfig{Snr: "504F94A00000"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "loxone"},
+	}
+	b := &Bridge{cfg: cfg, mqtt: mockMQTT}
+
+	will := b.will()
+	assert.Equal(t, "loxone/504F94A00000/_status", will.Topic)
+	assert.Equal(t, StatusOffline, string(will.Payload))
+	assert.True(t, will.Retained)
+
+	// Every (re)connect replaces the will
+	mockMQTT.On("Publish", "loxone/504F94A00000/_status", byte(1), true, StatusOnline).Return(nil).Once()
+	mockMQTT.On("Publish", "loxone/_bridge/status", byte(1), false, StatusOnline).Return(nil).Once()
+	b.onMQTTConnect()
+
+	mockMQTT.On("Publish", "loxone/504F94A00000/_status/loxone", byte(1), false, StatusOffline).Return(nil).Once()
+	b.publishLoxoneStatus(false)
+
+	mockMQTT.AssertExpectations(t)
+
+	// _status keeps the will with Homie, unless the Homie will is opted into
+	b.homie = newHomieDevice("homie", 4, cfg.Loxone.Snr)
+	assert.Equal(t, b.statusTopic(), b.will().Topic)
+	b.cfg.Bridge.HomieWill = true
+	will = b.will()
+	assert.Equal(t, "homie/lox-504f94a00000/$state", will.Topic)
+	assert.Equal(t, HomieLost, string(will.Payload))
+	assert.True(t, will.Retained)
+}
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"crypto/tls"
 	"fmt"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
@@ -11,6 +13,22 @@ type LoxoneConfig struct {
 	User string `envconfig:"LOXONE_USER" required:"true"`
 	Pass string `envconfig:"LOXONE_PASS" required:"true"`
 	Snr  string `envconfig:"LOXONE_SNR" required:"true"`
+
+	// Time zone of the Miniserver (IANA name, e.g. Europe/Zurich), the system zone if empty.
+	// Loxone times are wall-clock seconds since 2009-01-01 in this zone.
+	TimeZone string `envconfig:"LOXONE_TIMEZONE"`
+}
+
+// Location returns the time zone of the Miniserver
+func (c LoxoneConfig) Location() (*time.Location, error) {
+	if c.TimeZone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Loxone time zone: %w", err)
+	}
+	return loc, nil
 }
 
 type MQTTConfig struct {
@@ -22,6 +40,55 @@ type MQTTConfig struct {
 	User        string `envconfig:"MQTT_USER"`
 	Pass        string `envconfig:"MQTT_PASS"`
 	TopicPrefix string `envconfig:"MQTT_TOPIC_PREFIX" default:"lox"`
+
+	// Protocol version: 3 (3.1.1) or 5. Only v5 carries response topics, correlation data and user properties.
+	Version int `envconfig:"MQTT_VERSION" default:"3"`
+	// Message expiry of state messages, v5 only (0 disables)
+	MessageExpiry time.Duration `envconfig:"MQTT_MESSAGE_EXPIRY"`
+
+	// Persistent sessions keep subscriptions and undelivered QoS 1 messages on the broker
+	// while the bridge is disconnected. SessionExpiry applies to v5 only.
+	CleanSession  bool          `envconfig:"MQTT_CLEAN_SESSION" default:"true"`
+	SessionExpiry time.Duration `envconfig:"MQTT_SESSION_EXPIRY" default:"1h"`
+	// Directory of the client session store (in-flight messages), in memory if empty
+	StoreDir string `envconfig:"MQTT_STORE_DIR"`
+
+	// TLS settings for the ssl and wss protocols
+	TLS TLSConfig
+}
+
+type TLSConfig struct {
+	CAFile             string `envconfig:"MQTT_TLS_CA_FILE"`   // PEM bundle, replaces the system roots
+	CertFile           string `envconfig:"MQTT_TLS_CERT_FILE"` // Client certificate for mTLS
+	KeyFile            string `envconfig:"MQTT_TLS_KEY_FILE"`
+	MinVersion         string `envconfig:"MQTT_TLS_MIN_VERSION" default:"1.2"`
+	ServerName         string `envconfig:"MQTT_TLS_SERVER_NAME"` // SNI and verified name, defaults to the host
+	InsecureSkipVerify bool   `envconfig:"MQTT_TLS_INSECURE_SKIP_VERIFY" default:"false"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Version returns the minimum TLS version, TLS 1.2 if not set
+func (c TLSConfig) Version() uint16 {
+	if v, ok := tlsVersions[c.MinVersion]; ok {
+		return v
+	}
+	return tls.VersionTLS12
+}
+
+func (c TLSConfig) Validate() error {
+	if _, ok := tlsVersions[c.MinVersion]; !ok && c.MinVersion != "" {
+		return fmt.Errorf("invalid MQTT TLS min version: %s (must be 1.0, 1.1, 1.2 or 1.3)", c.MinVersion)
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("MQTT TLS client certificate and key must be set together")
+	}
+	return nil
 }
 
 func (c *MQTTConfig) Validate() error {
@@ -32,6 +99,19 @@ func (c *MQTTConfig) Validate() error {
 		return fmt.Errorf("invalid MQTT protocol: %s (must be tcp, ssl, ws, or wss)", c.Protocol)
 	}
 
+	switch c.Version {
+	case 0:
+		c.Version = 3
+	case 3, 5:
+		// valid
+	default:
+		return fmt.Errorf("invalid MQTT version: %d (must be 3 or 5)", c.Version)
+	}
+
+	if err := c.TLS.Validate(); err != nil {
+		return err
+	}
+
 	if (c.Protocol == "ws" || c.Protocol == "wss") && c.Path == "" {
 		c.Path = "/mqtt"
 	}
@@ -43,10 +123,88 @@ type SystemConfig struct {
 	LogLevel string `envconfig:"LOG_LEVEL" default:"info"`
 }
 
+type BridgeConfig struct {
+	MappingFile       string        `envconfig:"BRIDGE_MAPPING_FILE"`
+	AggregateState    bool          `envconfig:"BRIDGE_AGGREGATE_STATE" default:"false"`
+	AggregateDebounce time.Duration `envconfig:"BRIDGE_AGGREGATE_DEBOUNCE" default:"200ms"`
+
+	// Payload encoding of state topics: raw, json, extended or template
+	PayloadFormat   string            `envconfig:"BRIDGE_PAYLOAD_FORMAT" default:"json"`
+	PayloadFormats  map[string]string `envconfig:"BRIDGE_PAYLOAD_FORMATS"` // Key: "<type>" or "<type>_<state>"
+	PayloadTemplate string            `envconfig:"BRIDGE_PAYLOAD_TEMPLATE"`
+
+	// Publish suppression, class keys as for PayloadFormats
+	PublishDedupe       bool                     `envconfig:"BRIDGE_PUBLISH_DEDUPE" default:"false"`
+	PublishDeadband     map[string]string        `envconfig:"BRIDGE_PUBLISH_DEADBAND"` // "0.5" absolute or "2%" relative
+	PublishMinInterval  time.Duration            `envconfig:"BRIDGE_PUBLISH_MIN_INTERVAL"`
+	PublishMinIntervals map[string]time.Duration `envconfig:"BRIDGE_PUBLISH_MIN_INTERVALS"`
+	PublishMaxAge       time.Duration            `envconfig:"BRIDGE_PUBLISH_MAX_AGE"`
+
+	// Command authorization
+	ReadOnly          bool   `envconfig:"BRIDGE_READ_ONLY" default:"false"`
+	CommandPolicyFile string `envconfig:"BRIDGE_COMMAND_POLICY_FILE"`
+
+	// JSON file with macros, see Macros
+	MacrosFile string `envconfig:"BRIDGE_MACROS_FILE"`
+
+	// Commands received while Loxone is disconnected are queued (0 disables) and expire after the TTL
+	CommandQueueSize int           `envconfig:"BRIDGE_COMMAND_QUEUE_SIZE" default:"0"`
+	CommandTTL       time.Duration `envconfig:"BRIDGE_COMMAND_TTL" default:"30s"`
+
+	// Mirror state topics and accept commands under <prefix>/<snr>/uuid/<uuid>
+	UUIDTopics bool `envconfig:"BRIDGE_UUID_TOPICS" default:"false"`
+
+	// Classes published optimistically when a command is sent, rolled back after the timeout
+	// if Loxone does not confirm. Class keys as for PayloadFormats.
+	Optimistic        []string      `envconfig:"BRIDGE_OPTIMISTIC"`
+	OptimisticTimeout time.Duration `envconfig:"BRIDGE_OPTIMISTIC_TIMEOUT" default:"5s"`
+
+	// Audit log of command requests: sinks mqtt, file and stdout
+	Audit              []string `envconfig:"BRIDGE_AUDIT"`
+	AuditFile          string   `envconfig:"BRIDGE_AUDIT_FILE"`
+	AuditFileMaxSizeMB int      `envconfig:"BRIDGE_AUDIT_FILE_MAX_SIZE_MB" default:"10"`
+	AuditFileBackups   int      `envconfig:"BRIDGE_AUDIT_FILE_BACKUPS" default:"5"`
+
+	// Asynchronous state publishing: workers (0 publishes synchronously) and the bound of queued states
+	PublishWorkers   int `envconfig:"BRIDGE_PUBLISH_WORKERS" default:"0"`
+	PublishQueueSize int `envconfig:"BRIDGE_PUBLISH_QUEUE_SIZE" default:"1000"`
+	// Latest state message per topic kept while the broker is unreachable (0 disables), on disk
+	// if a directory is set. Flushed on reconnect, followed by a republish of all states.
+	BufferSize int    `envconfig:"BRIDGE_BUFFER_SIZE" default:"0"`
+	BufferDir  string `envconfig:"BRIDGE_BUFFER_DIR"`
+	// Interval of the metrics on <prefix>/_bridge/metrics (0 disables)
+	MetricsInterval time.Duration `envconfig:"BRIDGE_METRICS_INTERVAL" default:"0"`
+
+	// Home Assistant MQTT discovery of the supported control types
+	HassDiscovery       bool   `envconfig:"BRIDGE_HASS_DISCOVERY" default:"false"`
+	HassDiscoveryPrefix string `envconfig:"BRIDGE_HASS_DISCOVERY_PREFIX" default:"homeassistant"`
+
+	// Homie convention (version 4 or 5) below the Homie prefix
+	Homie        bool   `envconfig:"BRIDGE_HOMIE" default:"false"`
+	HomieVersion int    `envconfig:"BRIDGE_HOMIE_VERSION" default:"4"`
+	HomiePrefix  string `envconfig:"BRIDGE_HOMIE_PREFIX" default:"homie"`
+	// Register the Homie $state "lost" as MQTT will instead of _status "offline"
+	HomieWill bool `envconfig:"BRIDGE_HOMIE_WILL" default:"false"`
+
+	// Management requests on <prefix>/_bridge/request/<action>
+	Management bool `envconfig:"BRIDGE_MANAGEMENT" default:"false"`
+
+	// Duration of temperature overrides started via a set topic (IRC tempTarget)
+	TempOverride time.Duration `envconfig:"BRIDGE_TEMP_OVERRIDE" default:"1h"`
+}
+
 type Config struct {
 	Loxone LoxoneConfig
 	MQTT   MQTTConfig
 	System SystemConfig
+	Bridge BridgeConfig
+
+	// Mapping is loaded from Bridge.MappingFile, nil if not configured
+	Mapping *Mapping `ignored:"true"`
+	// Policy is loaded from Bridge.CommandPolicyFile, nil if not configured
+	Policy *CommandPolicy `ignored:"true"`
+	// Macros are loaded from Bridge.MacrosFile, nil if not configured
+	Macros *Macros `ignored:"true"`
 }
 
 func Load() (*Config, error) {
@@ -58,6 +216,33 @@ func Load() (*Config, error) {
 	if err := cfg.MQTT.Validate(); err != nil {
 		return nil, err
 	}
+	if _, err := cfg.Loxone.Location(); err != nil {
+		return nil, err
+	}
+
+	if cfg.Bridge.MappingFile != "" {
+		mapping, err := LoadMapping(cfg.Bridge.MappingFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Mapping = mapping
+	}
+
+	if cfg.Bridge.CommandPolicyFile != "" {
+		policy, err := LoadCommandPolicy(cfg.Bridge.CommandPolicyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Policy = policy
+	}
+
+	if cfg.Bridge.MacrosFile != "" {
+		macros, err := LoadMacros(cfg.Bridge.MacrosFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Macros = macros
+	}
 
 	return &cfg, nil
 }
@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -45,6 +46,38 @@ func TestMQTTConfig_Validate(t *testing.T) {
 			},
 			expectedErr: true,
 		},
+		{
+			name: "Valid Version 5",
+			cfg: MQTTConfig{
+				Protocol: "tcp",
+				Version:  5,
+			},
+			expectedErr: false,
+		},
+		{
+			name: "Invalid TLS Min Version",
+			cfg: MQTTConfig{
+				Protocol: "ssl",
+				TLS:      TLSConfig{MinVersion: "1.4"},
+			},
+			expectedErr: true,
+		},
+		{
+			name: "TLS Cert Without Key",
+			cfg: MQTTConfig{
+				Protocol: "ssl",
+				TLS:      TLSConfig{MinVersion: "1.2", CertFile: "client.pem"},
+			},
+			expectedErr: true,
+		},
+		{
+			name: "Invalid Version",
+			cfg: MQTTConfig{
+				Protocol: "tcp",
+				Version:  4,
+			},
+			expectedErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -62,3 +95,16 @@ func TestMQTTConfig_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestLoxoneConfig_Location(t *testing.T) {
+	loc, err := LoxoneConfig{}.Location()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Local, loc)
+
+	loc, err = LoxoneConfig{TimeZone: "UTC"}.Location()
+	assert.NoError(t, err)
+	assert.Equal(t, "UTC", loc.String())
+
+	_, err = LoxoneConfig{TimeZone: "Mars/Olympus"}.Location()
+	assert.Error(t, err)
+}
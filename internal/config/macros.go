@@ -0,0 +1,116 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Macros are named command sequences, triggered via <prefix>/<snr>/_macro/<name>/run
+type Macros struct {
+	Macros map[string]Macro `json:"macros"`
+}
+
+// Macro is a sequence of steps executed in order
+type Macro struct {
+	Steps           []MacroStep `json:"steps"`
+	ContinueOnError bool        `json:"continueOnError,omitempty"` // Run the remaining steps after a failed one
+}
+
+// MacroStep either sends a command to a control or waits for Delay
+type MacroStep struct {
+	// Control is the topic path "<room>/<control>" or the control's uuidAction
+	Control string `json:"control,omitempty"`
+	// Command is a command payload: a string in Loxone notation or a JSON command object
+	Command json.RawMessage `json:"command,omitempty"`
+	Delay   Duration        `json:"delay,omitempty"`
+	// If skips the step unless the condition holds
+	If *MacroCondition `json:"if,omitempty"`
+}
+
+// MacroCondition compares the current value of a state
+type MacroCondition struct {
+	// State is the topic path "<room>/<control>/<type>_<state>" or the state UUID
+	State string      `json:"state"`
+	Op    string      `json:"op,omitempty"` // eq (default), ne, gt, ge, lt, le
+	Value interface{} `json:"value"`
+}
+
+// Duration is a time.Duration written as a string in JSON files, e.g. "1.5s"
+type Duration time.Duration
+
+// UnmarshalJSON parses a Go duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"2s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Payload returns the command as MQTT payload: the bare string or the JSON object
+func (s MacroStep) Payload() []byte {
+	var str string
+	if err := json.Unmarshal(s.Command, &str); err == nil {
+		return []byte(str)
+	}
+	return s.Command
+}
+
+var macroOps = map[string]bool{"": true, "eq": true, "ne": true, "gt": true, "ge": true, "lt": true, "le": true}
+
+// LoadMacros reads and validates a JSON macros file
+func LoadMacros(path string) (*Macros, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read macros file: %w", err)
+	}
+
+	var m Macros
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse macros file: %w", err)
+	}
+
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// Validate checks macro names and that every step is either a command or a delay
+func (m *Macros) Validate() error {
+	for name, macro := range m.Macros {
+		if name == "" || strings.ContainsAny(name, "/+#") {
+			return fmt.Errorf("invalid macro name %q (must not be empty or contain '/', '+' or '#')", name)
+		}
+		if len(macro.Steps) == 0 {
+			return fmt.Errorf("macro %s: no steps", name)
+		}
+		for i, step := range macro.Steps {
+			isCommand := step.Control != "" || len(step.Command) > 0
+			if isCommand && (step.Control == "" || len(step.Command) == 0) {
+				return fmt.Errorf("macro %s step %d: control and command are both required", name, i+1)
+			}
+			if isCommand == (step.Delay > 0) {
+				return fmt.Errorf("macro %s step %d: must have either a command or a delay", name, i+1)
+			}
+			if step.If != nil {
+				if step.If.State == "" {
+					return fmt.Errorf("macro %s step %d: condition without state", name, i+1)
+				}
+				if !macroOps[step.If.Op] {
+					return fmt.Errorf("macro %s step %d: invalid condition op %q", name, i+1, step.If.Op)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMacros(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		expectedErr bool
+	}{
+		{
+			name: "Valid Macros",
+			content: `{"macros": {"movie-mode": {"steps": [
+				{"control": "living-room/spots", "command": "20"},
+				{"delay": "1.5s"},
+				{"control": "living-room/blinds", "command": {"cmd": "FullDown"}, "if": {"state": "living-room/blinds/jalousie_position", "op": "lt", "value": 1}}
+			]}}}`,
+		},
+		{
+			name:        "Invalid JSON",
+			content:     `{"macros": `,
+			expectedErr: true,
+		},
+		{
+			name:        "Invalid Name",
+			content:     `{"macros": {"movie/mode": {"steps": [{"delay": "1s"}]}}}`,
+			expectedErr: true,
+		},
+		{
+			name:        "Command Without Control",
+			content:     `{"macros": {"m": {"steps": [{"command": "On"}]}}}`,
+			expectedErr: true,
+		},
+		{
+			name:        "Command And Delay",
+			content:     `{"macros": {"m": {"steps": [{"control": "a/b", "command": "On", "delay": "1s"}]}}}`,
+			expectedErr: true,
+		},
+		{
+			name:        "Invalid Delay",
+			content:     `{"macros": {"m": {"steps": [{"delay": "soon"}]}}}`,
+			expectedErr: true,
+		},
+		{
+			name:        "Invalid Op",
+			content:     `{"macros": {"m": {"steps": [{"control": "a/b", "command": "On", "if": {"state": "a/b/c", "op": "like"}}]}}}`,
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "macros.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+
+			m, err := LoadMacros(path)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			steps := m.Macros["movie-mode"].Steps
+			require.Len(t, steps, 3)
+			assert.Equal(t, "20", string(steps[0].Payload()))
+			assert.Equal(t, Duration(1500*time.Millisecond), steps[1].Delay)
+			assert.JSONEq(t, `{"cmd": "FullDown"}`, string(steps[2].Payload()))
+			assert.Equal(t, "lt", steps[2].If.Op)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Mapping overrides the topic slugs derived from Loxone names.
+// Controls are keyed by their UUID (uuidAction) so topics survive renames in Loxone Config.
+type Mapping struct {
+	Controls map[string]ControlMapping `json:"controls"`
+}
+
+// ControlMapping holds the slug overrides for a single control.
+// Empty fields fall back to the sanitized Loxone name.
+type ControlMapping struct {
+	Room    string            `json:"room,omitempty"`
+	Control string            `json:"control,omitempty"`
+	States  map[string]string `json:"states,omitempty"` // Key: Loxone state name
+}
+
+// LoadMapping reads and validates a JSON mapping file
+func LoadMapping(path string) (*Mapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mapping file: %w", err)
+	}
+
+	var m Mapping
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse mapping file: %w", err)
+	}
+
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// reservedRoomSlugs are topic levels of the bridge below <prefix>/<snr>, next to the rooms
+var reservedRoomSlugs = map[string]bool{"uuid": true}
+
+// Validate rejects slugs that would break the topic hierarchy or wildcard subscriptions, and
+// slugs that collide with the bridge's own topics (uuid, and the "_" namespace such as _info)
+func (m *Mapping) Validate() error {
+	for id, cm := range m.Controls {
+		if reservedRoomSlugs[cm.Room] {
+			return fmt.Errorf("mapping for control %s: room slug %q is reserved", id, cm.Room)
+		}
+		slugs := []string{cm.Room, cm.Control}
+		for _, s := range cm.States {
+			if s == "" {
+				return fmt.Errorf("mapping for control %s: empty state slug", id)
+			}
+			slugs = append(slugs, s)
+		}
+		for _, s := range slugs {
+			if strings.ContainsAny(s, "/+#") {
+				return fmt.Errorf("mapping for control %s: invalid slug %q (must not contain '/', '+' or '#')", id, s)
+			}
+			if strings.HasPrefix(s, "_") {
+				return fmt.Errorf("mapping for control %s: invalid slug %q (must not start with '_')", id, s)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMapping(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		expectedErr bool
+	}{
+		{
+			name: "Valid Mapping",
+			content: `{"controls": {"10000000-0000-0000-0000000000000010": {
+				"room": "living-room", "control": "spots", "states": {"position": "level"}}}}`,
+			expectedErr: false,
+		},
+		{
+			name:        "Invalid JSON",
+			content:     `{"controls": `,
+			expectedErr: true,
+		},
+		{
+			name:        "Slug With Wildcard",
+			content:     `{"controls": {"10000000-0000-0000-0000000000000010": {"room": "living/#"}}}`,
+			expectedErr: true,
+		},
+		{
+			name:        "Reserved Room Slug",
+			content:     `{"controls": {"10000000-0000-0000-0000000000000010": {"room": "uuid"}}}`,
+			expectedErr: true,
+		},
+		{
+			name:        "Bridge Namespace Slug",
+			content:     `{"controls": {"10000000-0000-0000-0000000000000010": {"room": "_bridge"}}}`,
+			expectedErr: true,
+		},
+		{
+			name:        "Bridge Namespace State Slug",
+			content:     `{"controls": {"10000000-0000-0000-0000000000000010": {"states": {"active": "_info"}}}}`,
+			expectedErr: true,
+		},
+		{
+			name:        "Empty State Slug",
+			content:     `{"controls": {"10000000-0000-0000-0000000000000010": {"states": {"active": ""}}}}`,
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "mapping.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+
+			m, err := LoadMapping(path)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			cm := m.Controls["10000000-0000-0000-0000000000000010"]
+			assert.Equal(t, "living-room", cm.Room)
+			assert.Equal(t, "spots", cm.Control)
+			assert.Equal(t, "level", cm.States["position"])
+		})
+	}
+}
+
+func TestLoadMapping_MissingFile(t *testing.T) {
+	_, err := LoadMapping(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
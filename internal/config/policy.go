@@ -0,0 +1,71 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Policy effects
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+// CommandPolicy restricts which commands reach Loxone.
+// Rules are evaluated in order, the first matching rule decides; Default applies if none matches.
+type CommandPolicy struct {
+	Default string       `json:"default,omitempty"` // allow (default) or deny
+	Rules   []PolicyRule `json:"rules"`
+}
+
+// PolicyRule matches a command if every non-empty list contains the respective value.
+// Rooms, categories and controls are compared by name, controls also by uuidAction.
+// Commands are matched by name in Loxone notation, e.g. "on" matches "on" and "on/1".
+type PolicyRule struct {
+	Effect     string   `json:"effect"`
+	Rooms      []string `json:"rooms,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+	Types      []string `json:"types,omitempty"`
+	Controls   []string `json:"controls,omitempty"`
+	Commands   []string `json:"commands,omitempty"`
+}
+
+// LoadCommandPolicy reads and validates a JSON command policy file
+func LoadCommandPolicy(path string) (*CommandPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read command policy file: %w", err)
+	}
+
+	var p CommandPolicy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse command policy file: %w", err)
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// Validate rejects unknown effects
+func (p *CommandPolicy) Validate() error {
+	p.Default = strings.ToLower(p.Default)
+	if p.Default == "" {
+		p.Default = PolicyAllow
+	}
+	if p.Default != PolicyAllow && p.Default != PolicyDeny {
+		return fmt.Errorf("invalid command policy default: %s (must be allow or deny)", p.Default)
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		r.Effect = strings.ToLower(r.Effect)
+		if r.Effect != PolicyAllow && r.Effect != PolicyDeny {
+			return fmt.Errorf("command policy rule %d: invalid effect %q (must be allow or deny)", i+1, r.Effect)
+		}
+	}
+	return nil
+}
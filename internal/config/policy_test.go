@@ -0,0 +1,56 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCommandPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		expectedErr bool
+	}{
+		{
+			name:    "Valid Policy",
+			content: `{"rules": [{"effect": "Allow", "types": ["Alarm"], "commands": ["on"]}, {"effect": "deny", "types": ["Alarm", "Gate"]}]}`,
+		},
+		{
+			name:        "Invalid JSON",
+			content:     `{"rules": `,
+			expectedErr: true,
+		},
+		{
+			name:        "Invalid Effect",
+			content:     `{"rules": [{"effect": "maybe"}]}`,
+			expectedErr: true,
+		},
+		{
+			name:        "Invalid Default",
+			content:     `{"default": "block", "rules": []}`,
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policy.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+
+			p, err := LoadCommandPolicy(path)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, PolicyAllow, p.Default)
+			require.Len(t, p.Rules, 2)
+			assert.Equal(t, PolicyAllow, p.Rules[0].Effect)
+			assert.Equal(t, []string{"on"}, p.Rules[0].Commands)
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -17,6 +18,9 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// ErrNotConnected is returned by SendCommand while the WebSocket is down
+var ErrNotConnected = errors.New("not connected")
+
 // Response represents a generic Loxone WebSocket/HTTP response wrapper
 type Response struct {
 	LL struct {
@@ -62,6 +66,7 @@ type Client struct {
 	// Channels
 	msgChan chan []byte
 	Events  chan Event
+	states  chan bool // Connection state changes, see ConnectionState
 
 	// Header state for the read loop
 	header *Header
@@ -82,6 +87,21 @@ func (c *Client) GetEvents() <-chan Event {
 	return c.Events
 }
 
+// ConnectionState reports connection changes: true once authenticated, false when the
+// WebSocket is lost. Connect can be called again to reconnect.
+func (c *Client) ConnectionState() <-chan bool {
+	return c.states
+}
+
+// setState publishes a connection change without blocking the read loop
+func (c *Client) setState(connected bool) {
+	select {
+	case c.states <- connected:
+	default:
+		slog.Warn("Connection state channel full, dropping change", "connected", connected)
+	}
+}
+
 // NewClient creates a new Loxone client
 func NewClient(cfg config.LoxoneConfig) *Client {
 	// Create HTTP client with insecure skip verify for local IP connections
@@ -97,6 +117,7 @@ func NewClient(cfg config.LoxoneConfig) *Client {
 		cfg:        cfg,
 		msgChan:    make(chan []byte, 100),
 		Events:     make(chan Event, 1000),
+		states:     make(chan bool, 16),
 		done:       make(chan struct{}),
 		httpClient: httpClient,
 	}
@@ -106,6 +127,11 @@ func NewClient(cfg config.LoxoneConfig) *Client {
 func (c *Client) Connect() error {
 	c.mu.Lock()
 
+	if c.isClosed {
+		c.mu.Unlock()
+		return fmt.Errorf("client is closed")
+	}
+
 	if c.cfg.Snr == "" {
 		c.mu.Unlock()
 		return fmt.Errorf("serial number (snr) is required for TLS connection")
@@ -142,18 +168,30 @@ func (c *Client) Connect() error {
 		return fmt.Errorf("websocket dial failed: %v", err)
 	}
 	c.isConnected = true
+	c.header = nil
+	connDone := make(chan struct{}) // Closed when this connection's read loop exits
+	conn := c.conn
 	c.mu.Unlock()
 
 	// Start reading messages
-	go c.readLoop()
+	go c.readLoop(conn, connDone)
 	// Start keepalive loop
-	go c.keepAliveLoop()
+	go c.keepAliveLoop(connDone)
 
 	// 3. Authenticate
 	if err := c.Authenticate(); err != nil {
+		// Detach first so the read loop doesn't report a lost connection that never was up
+		c.mu.Lock()
+		if c.conn == conn {
+			c.conn = nil
+			c.isConnected = false
+		}
+		c.mu.Unlock()
+		conn.Close()
 		return fmt.Errorf("authentication failed: %v", err)
 	}
 
+	c.setState(true)
 	return nil
 }
 
@@ -187,15 +225,22 @@ func (c *Client) checkReachability(scheme, host string, port int) error {
 	return nil
 }
 
-// readLoop handles incoming messages
-func (c *Client) readLoop() {
+// readLoop handles incoming messages of one connection
+func (c *Client) readLoop(conn *websocket.Conn, connDone chan struct{}) {
 	defer func() {
-		if c.conn != nil {
-			c.conn.Close()
-		}
+		conn.Close()
+		close(connDone)
 		c.mu.Lock()
-		c.isConnected = false
+		current := c.conn == conn
+		if current {
+			c.isConnected = false
+		}
+		closed := c.isClosed
 		c.mu.Unlock()
+		if current && !closed {
+			slog.Warn("Loxone connection lost")
+			c.setState(false)
+		}
 	}()
 
 	for {
@@ -203,7 +248,7 @@ func (c *Client) readLoop() {
 		case <-c.done:
 			return
 		default:
-			msgType, message, err := c.conn.ReadMessage()
+			msgType, message, err := conn.ReadMessage()
 			if err != nil {
 				return
 			}
@@ -264,8 +309,8 @@ func (c *Client) readLoop() {
 	}
 }
 
-// keepAliveLoop sends a keepalive message every 4 minutes
-func (c *Client) keepAliveLoop() {
+// keepAliveLoop sends a keepalive message every 4 minutes while the connection is up
+func (c *Client) keepAliveLoop(connDone chan struct{}) {
 	ticker := time.NewTicker(4 * time.Minute)
 	defer ticker.Stop()
 
@@ -273,6 +318,8 @@ func (c *Client) keepAliveLoop() {
 		select {
 		case <-c.done:
 			return
+		case <-connDone:
+			return
 		case <-ticker.C:
 			if err := c.SendCommand("keepalive"); err != nil {
 				slog.Error("Error sending keepalive", "error", err)
@@ -306,7 +353,7 @@ func (c *Client) SendCommand(cmd string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if !c.isConnected {
-		return fmt.Errorf("not connected")
+		return ErrNotConnected
 	}
 	return c.conn.WriteMessage(websocket.TextMessage, []byte(cmd))
 }
@@ -316,6 +363,16 @@ func (c *Client) Done() <-chan struct{} {
 	return c.done
 }
 
+// Disconnect drops the current connection without closing the client. The read loop reports
+// the loss on ConnectionState, Connect reconnects.
+func (c *Client) Disconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
 // Close closes the connection
 func (c *Client) Close() {
 	c.mu.Lock()
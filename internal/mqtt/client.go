@@ -1,6 +1,7 @@
 package mqtt
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
@@ -9,17 +10,22 @@ import (
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
+// Client is the MQTT 3.1.1 client, it does not transport v5 properties
 type Client struct {
 	client mqtt.Client
 	cfg    config.MQTTConfig
+	subs   subscriptions
 }
 
-func NewClient(cfg config.MQTTConfig) *Client {
-	broker := fmt.Sprintf("%s://%s:%d", cfg.Protocol, cfg.Host, cfg.Port)
-	if cfg.Path != "" {
-		broker = fmt.Sprintf("%s%s", broker, cfg.Path)
+func NewClient(cfg config.MQTTConfig, o Options) (*Client, error) {
+	tlsCfg, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
 	}
 
+	c := &Client{cfg: cfg}
+	broker := brokerURL(cfg)
+
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(broker)
 	opts.SetClientID(cfg.ClientID)
@@ -29,22 +35,44 @@ func NewClient(cfg config.MQTTConfig) *Client {
 		opts.SetPassword(cfg.Pass)
 	}
 
+	if tlsCfg != nil {
+		opts.SetTLSConfig(tlsCfg)
+	}
+
+	if o.Will != nil {
+		opts.SetBinaryWill(o.Will.Topic, o.Will.Payload, o.Will.QoS, o.Will.Retained)
+	}
+
+	opts.SetCleanSession(cfg.CleanSession)
+	if cfg.StoreDir != "" {
+		opts.SetStore(mqtt.NewFileStore(cfg.StoreDir))
+	}
+
 	opts.SetAutoReconnect(true)
 	opts.SetConnectRetry(true)
 	opts.SetConnectRetryInterval(5 * time.Second)
 
-	opts.OnConnect = func(c mqtt.Client) {
+	opts.OnConnect = func(mqtt.Client) {
 		slog.Info("Connected to MQTT broker", "broker", broker)
+		c.resubscribe()
+		if o.OnConnect != nil {
+			o.OnConnect()
+		}
 	}
-	opts.OnConnectionLost = func(c mqtt.Client, err error) {
+	opts.OnConnectionLost = func(_ mqtt.Client, err error) {
 		slog.Warn("Lost connection to MQTT broker", "error", err)
 	}
 
-	client := mqtt.NewClient(opts)
-	return &Client{
-		client: client,
-		cfg:    cfg,
+	c.client = mqtt.NewClient(opts)
+	return c, nil
+}
+
+func brokerURL(cfg config.MQTTConfig) string {
+	broker := fmt.Sprintf("%s://%s:%d", cfg.Protocol, cfg.Host, cfg.Port)
+	if cfg.Path != "" {
+		broker = fmt.Sprintf("%s%s", broker, cfg.Path)
 	}
+	return broker
 }
 
 func (c *Client) Connect() error {
@@ -56,36 +84,72 @@ func (c *Client) Connect() error {
 }
 
 func (c *Client) Publish(topic string, qos byte, retained bool, payload interface{}) error {
-	var p interface{}
-	switch v := payload.(type) {
-	case string:
-		p = v
-	case []byte:
-		p = v
-	case int, int32, int64:
-		p = fmt.Sprintf("%d", v)
-	case float32, float64:
-		p = fmt.Sprintf("%f", v)
-	case bool:
-		p = fmt.Sprintf("%t", v)
-	default:
-		return fmt.Errorf("unknown payload type")
+	p, err := encodePayload(payload)
+	if err != nil {
+		return err
+	}
+
+	// While reconnecting paho drops QoS 0 messages silently and holds QoS 1 messages
+	// until the connection is up again, which would block the caller
+	if !c.client.IsConnectionOpen() {
+		return ErrNotConnected
 	}
 
 	token := c.client.Publish(topic, qos, retained, p)
+	if !token.WaitTimeout(publishTimeout) {
+		return fmt.Errorf("%w: not acknowledged within %s", ErrNotConnected, publishTimeout)
+	}
+	if err := token.Error(); err != nil {
+		if errors.Is(err, mqtt.ErrNotConnected) || !c.client.IsConnectionOpen() {
+			return fmt.Errorf("%w: %v", ErrNotConnected, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// Connected reports whether the connection to the broker is up, Publish fails while it is not
+func (c *Client) Connected() bool {
+	return c.client.IsConnectionOpen()
+}
+
+// PublishMessage publishes a message, its properties are dropped
+func (c *Client) PublishMessage(msg *Message) error {
+	return c.Publish(msg.Topic, msg.QoS, msg.Retained, msg.Payload)
+}
+
+// Subscribe subscribes to a topic, the subscription is restored after reconnects
+func (c *Client) Subscribe(topic string, qos byte, callback func(msg *Message)) error {
+	sub := subscription{filter: topic, qos: qos, callback: callback}
+	c.subs.add(sub)
+	return c.subscribe(sub)
+}
+
+func (c *Client) subscribe(sub subscription) error {
+	token := c.client.Subscribe(sub.filter, sub.qos, func(client mqtt.Client, msg mqtt.Message) {
+		sub.callback(&Message{Topic: msg.Topic(), Payload: msg.Payload(), QoS: msg.Qos(), Retained: msg.Retained()})
+	})
 	token.Wait()
 	return token.Error()
 }
 
-// Subscribe subscribes to a topic
-func (c *Client) Subscribe(topic string, qos byte, callback func(topic string, payload []byte)) error {
-	token := c.client.Subscribe(topic, qos, func(client mqtt.Client, msg mqtt.Message) {
-		callback(msg.Topic(), msg.Payload())
-	})
+// Unsubscribe removes a subscription, it is not restored after reconnects
+func (c *Client) Unsubscribe(topic string) error {
+	c.subs.remove(topic)
+	token := c.client.Unsubscribe(topic)
 	token.Wait()
 	return token.Error()
 }
 
+// resubscribe re-establishes all subscriptions, called on every (re)connect
+func (c *Client) resubscribe() {
+	for _, sub := range c.subs.all() {
+		if err := c.subscribe(sub); err != nil {
+			slog.Error("Failed to resubscribe", "topic", sub.filter, "error", err)
+		}
+	}
+}
+
 func (c *Client) Close() {
 	if c.client.IsConnected() {
 		c.client.Disconnect(250)
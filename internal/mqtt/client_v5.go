@@ -0,0 +1,272 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/eclipse/paho.golang/paho/session/state"
+	"github.com/eclipse/paho.golang/paho/store/file"
+)
+
+// ClientV5 is the MQTT v5 client, it transports response topics, correlation data,
+// user properties and message expiry
+type ClientV5 struct {
+	cfg    config.MQTTConfig
+	opts   Options
+	tls    *tls.Config
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	cm        *autopaho.ConnectionManager
+	subs      subscriptions
+	connected atomic.Bool
+}
+
+func NewClientV5(cfg config.MQTTConfig, opts Options) (*ClientV5, error) {
+	tlsCfg, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ClientV5{cfg: cfg, opts: opts, tls: tlsCfg, ctx: ctx, cancel: cancel}, nil
+}
+
+func (c *ClientV5) Connect() error {
+	broker := brokerURL(c.cfg)
+	u, err := url.Parse(broker)
+	if err != nil {
+		return fmt.Errorf("invalid broker URL %s: %w", broker, err)
+	}
+
+	cc := autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{u},
+		TlsCfg:                        c.tls,
+		KeepAlive:                     30,
+		CleanStartOnInitialConnection: c.cfg.CleanSession,
+		ConnectRetryDelay:             5 * time.Second,
+		OnConnectionUp: func(cm *autopaho.ConnectionManager, _ *paho.Connack) {
+			slog.Info("Connected to MQTT broker", "broker", broker, "version", 5)
+			c.connected.Store(true)
+			go func() {
+				c.resubscribe(cm)
+				if c.opts.OnConnect != nil {
+					c.opts.OnConnect()
+				}
+			}()
+		},
+		OnConnectionDown: func() bool {
+			c.connected.Store(false)
+			slog.Warn("Lost connection to MQTT broker")
+			return true
+		},
+		OnConnectError: func(err error) {
+			slog.Warn("Failed to connect to MQTT broker", "error", err)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID:          c.cfg.ClientID,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){c.route},
+		},
+	}
+	if !c.cfg.CleanSession {
+		cc.SessionExpiryInterval = uint32(c.cfg.SessionExpiry / time.Second)
+	}
+	if c.cfg.StoreDir != "" {
+		session, err := newSessionStore(c.cfg.StoreDir)
+		if err != nil {
+			return err
+		}
+		cc.Session = session
+	}
+	if w := c.opts.Will; w != nil {
+		cc.WillMessage = &paho.WillMessage{Topic: w.Topic, Payload: w.Payload, QoS: w.QoS, Retain: w.Retained}
+	}
+	if c.cfg.User != "" && c.cfg.Pass != "" {
+		cc.ConnectUsername = c.cfg.User
+		cc.ConnectPassword = []byte(c.cfg.Pass)
+	}
+
+	slog.Info("Connecting to MQTT broker...")
+	cm, err := autopaho.NewConnection(c.ctx, cc)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cm = cm
+	c.mu.Unlock()
+
+	return cm.AwaitConnection(c.ctx)
+}
+
+func (c *ClientV5) Publish(topic string, qos byte, retained bool, payload interface{}) error {
+	p, err := encodePayload(payload)
+	if err != nil {
+		return err
+	}
+	return c.PublishMessage(&Message{Topic: topic, QoS: qos, Retained: retained, Payload: p})
+}
+
+// PublishMessage publishes a message together with its properties
+func (c *ClientV5) PublishMessage(msg *Message) error {
+	cm := c.manager()
+	if cm == nil {
+		return ErrNotConnected
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, publishTimeout)
+	defer cancel()
+	_, err := cm.Publish(ctx, &paho.Publish{
+		Topic:      msg.Topic,
+		QoS:        msg.QoS,
+		Retain:     msg.Retained,
+		Payload:    msg.Payload,
+		Properties: toPaho(msg.Properties),
+	})
+	if errors.Is(err, autopaho.ConnectionDownError) {
+		return ErrNotConnected
+	}
+	if err != nil && (!c.Connected() || errors.Is(err, context.DeadlineExceeded)) {
+		// Lost while the message was in flight
+		return fmt.Errorf("%w: %v", ErrNotConnected, err)
+	}
+	return err
+}
+
+// Connected reports whether the connection to the broker is up
+func (c *ClientV5) Connected() bool {
+	return c.connected.Load()
+}
+
+// Subscribe subscribes to a topic, the subscription is restored after reconnects
+func (c *ClientV5) Subscribe(topic string, qos byte, callback func(msg *Message)) error {
+	cm := c.manager()
+	if cm == nil {
+		return fmt.Errorf("not connected to MQTT broker")
+	}
+
+	c.subs.add(subscription{filter: topic, qos: qos, callback: callback})
+
+	_, err := cm.Subscribe(c.ctx, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: topic, QoS: qos}},
+	})
+	return err
+}
+
+// Unsubscribe removes a subscription, it is not restored after reconnects
+func (c *ClientV5) Unsubscribe(topic string) error {
+	c.subs.remove(topic)
+	cm := c.manager()
+	if cm == nil {
+		return fmt.Errorf("not connected to MQTT broker")
+	}
+	_, err := cm.Unsubscribe(c.ctx, &paho.Unsubscribe{Topics: []string{topic}})
+	return err
+}
+
+func (c *ClientV5) Close() {
+	if cm := c.manager(); cm != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+		defer cancel()
+		_ = cm.Disconnect(ctx)
+	}
+	c.cancel()
+}
+
+func (c *ClientV5) manager() *autopaho.ConnectionManager {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cm
+}
+
+func (c *ClientV5) resubscribe(cm *autopaho.ConnectionManager) {
+	for _, s := range c.subs.all() {
+		if _, err := cm.Subscribe(c.ctx, &paho.Subscribe{
+			Subscriptions: []paho.SubscribeOptions{{Topic: s.filter, QoS: s.qos}},
+		}); err != nil {
+			slog.Error("Failed to resubscribe", "topic", s.filter, "error", err)
+		}
+	}
+}
+
+// route dispatches a received message to the callbacks of all matching subscriptions
+func (c *ClientV5) route(pr paho.PublishReceived) (bool, error) {
+	p := pr.Packet
+	msg := &Message{
+		Topic:      p.Topic,
+		Payload:    p.Payload,
+		QoS:        p.QoS,
+		Retained:   p.Retain,
+		Properties: fromPaho(p.Properties),
+	}
+
+	subs := c.subs.matching(p.Topic)
+	for _, s := range subs {
+		s.callback(msg)
+	}
+	return len(subs) > 0, nil
+}
+
+// newSessionStore keeps the v5 session state (in-flight messages) in files below dir
+func newSessionStore(dir string) (*state.State, error) {
+	client, err := file.New(dir, "client", ".msg")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open MQTT store: %w", err)
+	}
+	server, err := file.New(dir, "server", ".msg")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open MQTT store: %w", err)
+	}
+	return state.New(client, server), nil
+}
+
+func toPaho(p *Properties) *paho.PublishProperties {
+	if p == nil {
+		return nil
+	}
+	props := &paho.PublishProperties{
+		ResponseTopic:   p.ResponseTopic,
+		CorrelationData: p.CorrelationData,
+		ContentType:     p.ContentType,
+	}
+	if p.MessageExpiry > 0 {
+		secs := uint32(p.MessageExpiry / time.Second)
+		if secs == 0 {
+			secs = 1
+		}
+		props.MessageExpiry = &secs
+	}
+	for k, v := range p.User {
+		props.User.Add(k, v)
+	}
+	return props
+}
+
+func fromPaho(p *paho.PublishProperties) *Properties {
+	if p == nil {
+		return nil
+	}
+	props := &Properties{
+		ResponseTopic:   p.ResponseTopic,
+		CorrelationData: p.CorrelationData,
+		ContentType:     p.ContentType,
+	}
+	if p.MessageExpiry != nil {
+		props.MessageExpiry = time.Duration(*p.MessageExpiry) * time.Second
+	}
+	if len(p.User) > 0 {
+		props.User = make(map[string]string, len(p.User))
+		for _, u := range p.User {
+			props.User[u.Key] = u.Value
+		}
+	}
+	return props
+}
@@ -0,0 +1,88 @@
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotConnected is returned by Publish if the connection to the broker is down or was lost
+// before the message was delivered
+var ErrNotConnected = errors.New("not connected to MQTT broker")
+
+// publishTimeout bounds the wait for the broker to acknowledge a publish, so a stalled
+// connection can't block the caller
+var publishTimeout = 10 * time.Second
+
+// Message is an MQTT message with its MQTT v5 properties
+type Message struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retained bool
+
+	// Properties are only transported by the v5 client, nil if the message has none
+	Properties *Properties
+}
+
+// Properties are the MQTT v5 publish properties used by the bridge
+type Properties struct {
+	ResponseTopic   string
+	CorrelationData []byte
+	ContentType     string
+	MessageExpiry   time.Duration // 0: never expires
+	User            map[string]string
+}
+
+// ResponseTopic returns the response topic requested by the sender, empty if none
+func (m *Message) ResponseTopic() string {
+	if m == nil || m.Properties == nil {
+		return ""
+	}
+	return m.Properties.ResponseTopic
+}
+
+// Options are the connection settings provided by the bridge
+type Options struct {
+	// Will is published by the broker when the connection is lost unexpectedly, nil for none
+	Will *Message
+	// OnConnect is called after every successful (re)connect, in its own goroutine
+	OnConnect func()
+}
+
+// encodePayload converts the payload types accepted by Publish to bytes
+func encodePayload(payload interface{}) ([]byte, error) {
+	switch v := payload.(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	case int, int32, int64:
+		return []byte(fmt.Sprintf("%d", v)), nil
+	case float32:
+		return []byte(strconv.FormatFloat(float64(v), 'f', -1, 32)), nil
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64)), nil
+	case bool:
+		return []byte(fmt.Sprintf("%t", v)), nil
+	default:
+		return nil, fmt.Errorf("unknown payload type")
+	}
+}
+
+// matchTopic reports whether a topic matches a subscription filter with + and # wildcards
+func matchTopic(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) || (level != "+" && level != t[i]) {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
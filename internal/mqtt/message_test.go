@@ -0,0 +1,53 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"lox/snr/+/+/command", "lox/snr/room/light/command", true},
+		{"lox/snr/+/+/command", "lox/snr/room/light/get", false},
+		{"lox/snr/+/+/command", "lox/snr/room/command", false},
+		{"lox/snr/#", "lox/snr/room/light/command", true},
+		{"lox/snr/#", "lox/other/room", false},
+		{"lox/snr", "lox/snr/room", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, matchTopic(tt.filter, tt.topic), "%s ~ %s", tt.filter, tt.topic)
+	}
+}
+
+func TestEncodePayload(t *testing.T) {
+	for payload, want := range map[interface{}]string{"on": "on", 42: "42", 1.5: "1.5", true: "true"} {
+		b, err := encodePayload(payload)
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(b))
+	}
+	_, err := encodePayload(struct{}{})
+	assert.Error(t, err)
+}
+
+func TestPropertiesRoundTrip(t *testing.T) {
+	props := &Properties{
+		ResponseTopic:   "app/responses",
+		CorrelationData: []byte("req-1"),
+		ContentType:     "application/json",
+		MessageExpiry:   90 * time.Second,
+		User:            map[string]string{"uuid": "0f0f", "room": "Kitchen"},
+	}
+
+	p := toPaho(props)
+	assert.Equal(t, uint32(90), *p.MessageExpiry)
+	assert.Equal(t, "Kitchen", p.User.Get("room"))
+	assert.Equal(t, props, fromPaho(p))
+
+	assert.Nil(t, toPaho(nil))
+	assert.Nil(t, fromPaho(nil))
+}
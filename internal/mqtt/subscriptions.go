@@ -0,0 +1,58 @@
+package mqtt
+
+import "sync"
+
+type subscription struct {
+	filter   string
+	qos      byte
+	callback func(msg *Message)
+}
+
+// subscriptions tracks the active subscriptions of a client, they are re-established after
+// every reconnect because a clean session loses them on the broker
+type subscriptions struct {
+	mu   sync.Mutex
+	list []subscription
+}
+
+// add registers a subscription, replacing an existing one for the same filter
+func (s *subscriptions) add(sub subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, existing := range s.list {
+		if existing.filter == sub.filter {
+			s.list[i] = sub
+			return
+		}
+	}
+	s.list = append(s.list, sub)
+}
+
+// remove forgets the subscription of a filter
+func (s *subscriptions) remove(filter string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, existing := range s.list {
+		if existing.filter == filter {
+			s.list = append(s.list[:i], s.list[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *subscriptions) all() []subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]subscription(nil), s.list...)
+}
+
+// matching returns the subscriptions whose filter matches the topic
+func (s *subscriptions) matching(topic string) []subscription {
+	var subs []subscription
+	for _, sub := range s.all() {
+		if matchTopic(sub.filter, topic) {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
@@ -0,0 +1,31 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptions(t *testing.T) {
+	var subs subscriptions
+	var got []string
+	handler := func(name string) func(msg *Message) {
+		return func(msg *Message) { got = append(got, name+":"+msg.Topic) }
+	}
+
+	subs.add(subscription{filter: "lox/snr/+/+/command", qos: 1, callback: handler("old")})
+	subs.add(subscription{filter: "lox/snr/+/+/+/set", qos: 1, callback: handler("set")})
+	// Subscribing again replaces the handler instead of adding a second subscription
+	subs.add(subscription{filter: "lox/snr/+/+/command", qos: 1, callback: handler("command")})
+	assert.Len(t, subs.all(), 2)
+
+	for _, s := range subs.matching("lox/snr/room/light/command") {
+		s.callback(&Message{Topic: "lox/snr/room/light/command"})
+	}
+	assert.Equal(t, []string{"command:lox/snr/room/light/command"}, got)
+	assert.Empty(t, subs.matching("lox/snr/room/light/get"))
+
+	subs.remove("lox/snr/+/+/command")
+	assert.Empty(t, subs.matching("lox/snr/room/light/command"))
+	assert.Len(t, subs.all(), 1)
+}
@@ -0,0 +1,45 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+)
+
+// newTLSConfig builds the TLS settings of ssl and wss connections, nil for plain protocols
+func newTLSConfig(cfg config.MQTTConfig) (*tls.Config, error) {
+	if cfg.Protocol != "ssl" && cfg.Protocol != "wss" {
+		return nil, nil
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion:         cfg.TLS.Version(),
+		ServerName:         cfg.TLS.ServerName,
+		InsecureSkipVerify: cfg.TLS.InsecureSkipVerify,
+	}
+
+	if cfg.TLS.CAFile != "" {
+		pem, err := os.ReadFile(cfg.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MQTT CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in MQTT CA file %s", cfg.TLS.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if cfg.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load MQTT client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
@@ -0,0 +1,75 @@
+package mqtt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chrisrickenbacher/lox-mqtt-bridge/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate writes a self-signed certificate and its key as PEM files
+func writeCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "lox-bridge"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir)
+
+	// Plain protocols don't use TLS
+	tlsCfg, err := newTLSConfig(config.MQTTConfig{Protocol: "tcp", TLS: config.TLSConfig{CAFile: certFile}})
+	require.NoError(t, err)
+	assert.Nil(t, tlsCfg)
+
+	tlsCfg, err = newTLSConfig(config.MQTTConfig{Protocol: "ssl", TLS: config.TLSConfig{
+		CAFile:     certFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		MinVersion: "1.3",
+		ServerName: "broker.local",
+	}})
+	require.NoError(t, err)
+	assert.NotNil(t, tlsCfg.RootCAs)
+	assert.Len(t, tlsCfg.Certificates, 1)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsCfg.MinVersion)
+	assert.Equal(t, "broker.local", tlsCfg.ServerName)
+	assert.False(t, tlsCfg.InsecureSkipVerify)
+
+	// A CA file without certificates
+	_, err = newTLSConfig(config.MQTTConfig{Protocol: "wss", TLS: config.TLSConfig{CAFile: keyFile}})
+	assert.Error(t, err)
+
+	_, err = newTLSConfig(config.MQTTConfig{Protocol: "ssl", TLS: config.TLSConfig{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.pem")}})
+	assert.Error(t, err)
+}